@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+type loginPage struct {
+	Register  bool
+	Bootstrap bool // 尚无账号，注册的是第一个管理员
+	Error     string
+}
+
+func renderLogin(w http.ResponseWriter, status int, page loginPage) {
+	renderPage(w, status, "login.html", page)
+}
+
+// loginHandler 登录页面，成功后写入会话 Cookie
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		if noUsers() {
+			http.Redirect(w, r, "/register", http.StatusSeeOther)
+			return
+		}
+		renderLogin(w, http.StatusOK, loginPage{})
+		return
+	}
+	u, err := store.GetUser(r.FormValue("name"))
+	if err != nil || !u.checkPassword(r.FormValue("password")) {
+		renderLogin(w, http.StatusUnauthorized, loginPage{Error: "用户名或密码错误"})
+		return
+	}
+	startSession(w, r, u.Name)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "请使用 POST", http.StatusMethodNotAllowed)
+		return
+	}
+	endSession(w, r)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// registerHandler 创建账号：尚无账号时任何人都可以注册第一个管理员，之后只有管理员可以创建账号
+func registerHandler(w http.ResponseWriter, r *http.Request) {
+	bootstrap := noUsers()
+	admin := currentUser(r)
+	if !bootstrap && (admin == nil || !admin.Admin) {
+		http.Error(w, "只有管理员可以创建账号", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPost {
+		renderLogin(w, http.StatusOK, loginPage{Register: true, Bootstrap: bootstrap})
+		return
+	}
+
+	u, err := newUser(strings.TrimSpace(r.FormValue("name")), r.FormValue("password"),
+		bootstrap || r.FormValue("admin") != "", splitList(r.FormValue("teams")))
+	if err == nil {
+		err = createUser(u, bootstrap)
+	}
+	if errors.Is(err, ErrUserExists) {
+		renderLogin(w, http.StatusConflict, loginPage{Register: true, Bootstrap: bootstrap, Error: "用户名已存在"})
+		return
+	}
+	if errors.Is(err, ErrUsersExist) {
+		renderLogin(w, http.StatusConflict, loginPage{Register: true, Error: "管理员已创建，请登录"})
+		return
+	}
+	if err != nil {
+		renderLogin(w, http.StatusBadRequest, loginPage{Register: true, Bootstrap: bootstrap, Error: err.Error()})
+		return
+	}
+
+	if bootstrap {
+		startSession(w, r, u.Name)
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// apiUserView 接口返回的用户信息，不包含密码与 Key 的哈希
+type apiUserView struct {
+	Name      string       `json:"name"`
+	Admin     bool         `json:"admin"`
+	Teams     []string     `json:"teams,omitempty"`
+	APIKeys   []apiKeyView `json:"api_keys"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+type apiKeyView struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Key       string    `json:"key,omitempty"` // 明文，仅在创建时返回
+}
+
+func newUserView(u *User) apiUserView {
+	v := apiUserView{Name: u.Name, Admin: u.Admin, Teams: u.Teams, APIKeys: []apiKeyView{}, CreatedAt: u.CreatedAt}
+	for _, k := range u.APIKeys {
+		v.APIKeys = append(v.APIKeys, apiKeyView{ID: k.ID, Name: k.Name, CreatedAt: k.CreatedAt})
+	}
+	return v
+}
+
+// apiUsersHandler 处理 /api/v1/users 下的请求，parts 为 users 之后的路径段。
+// 尚无账号时可以匿名创建第一个管理员，之后创建与列出账号需要管理员权限
+func apiUsersHandler(w http.ResponseWriter, r *http.Request, parts []string) {
+	if len(parts) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			if u := apiUser(w, r); u != nil {
+				apiListUsers(w, u)
+			}
+		case http.MethodPost:
+			apiCreateUser(w, r)
+		default:
+			methodNotAllowed(w, "GET, POST")
+		}
+		return
+	}
+	if parts[0] != "me" || len(parts) > 3 || (len(parts) > 1 && parts[1] != "keys") {
+		writeAPIError(w, http.StatusNotFound, "not_found", "unknown endpoint")
+		return
+	}
+	u := apiUser(w, r)
+	if u == nil {
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, newUserView(u))
+	case len(parts) == 1:
+		methodNotAllowed(w, "GET")
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, struct {
+			Keys []apiKeyView `json:"keys"`
+		}{newUserView(u).APIKeys})
+	case len(parts) == 2 && r.Method == http.MethodPost:
+		var req struct {
+			Name string `json:"name"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+				writeAPIError(w, http.StatusBadRequest, "invalid_request", "decode body: "+err.Error())
+				return
+			}
+		}
+		key, plain := u.newAPIKey(req.Name)
+		if err := store.UpdateUser(u); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "internal", err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, apiKeyView{ID: key.ID, Name: key.Name, CreatedAt: key.CreatedAt, Key: plain})
+	case len(parts) == 2:
+		methodNotAllowed(w, "GET, POST")
+	case r.Method == http.MethodDelete:
+		n := len(u.APIKeys)
+		u.APIKeys = slices.DeleteFunc(u.APIKeys, func(k APIKey) bool { return k.ID == parts[2] })
+		if len(u.APIKeys) == n {
+			writeAPIError(w, http.StatusNotFound, "not_found", "api key not found")
+			return
+		}
+		if err := store.UpdateUser(u); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "internal", err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, "DELETE")
+	}
+}
+
+func apiListUsers(w http.ResponseWriter, u *User) {
+	if !u.Admin {
+		writeAPIError(w, http.StatusForbidden, "forbidden", "admin required")
+		return
+	}
+	users, err := store.ListUsers()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	list := make([]apiUserView, 0, len(users))
+	for _, u := range users {
+		list = append(list, newUserView(u))
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Users []apiUserView `json:"users"`
+	}{list})
+}
+
+func apiCreateUser(w http.ResponseWriter, r *http.Request) {
+	bootstrap := noUsers()
+	if !bootstrap {
+		admin := apiUser(w, r)
+		if admin == nil {
+			return
+		}
+		if !admin.Admin {
+			writeAPIError(w, http.StatusForbidden, "forbidden", "admin required")
+			return
+		}
+	}
+	var req struct {
+		Name     string   `json:"name"`
+		Password string   `json:"password"`
+		Admin    bool     `json:"admin"`
+		Teams    []string `json:"teams"`
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "decode body: "+err.Error())
+		return
+	}
+	u, err := newUser(strings.TrimSpace(req.Name), req.Password, bootstrap || req.Admin, req.Teams)
+	if err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, "invalid_user", err.Error())
+		return
+	}
+	if err := createUser(u, bootstrap); errors.Is(err, ErrUserExists) {
+		writeAPIError(w, http.StatusConflict, "conflict", "user already exists")
+		return
+	} else if errors.Is(err, ErrUsersExist) {
+		writeAPIError(w, http.StatusConflict, "conflict", "the first admin has already been created")
+		return
+	} else if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, newUserView(u))
+}
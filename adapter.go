@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"webhook-proxy/im"
+)
+
+// errUnsupportedEvent 适配器无法处理该事件类型，事件会被跳过
+var errUnsupportedEvent = errors.New("unsupported event")
+
+// EventAdapter 将特定来源的 Webhook 负载转换为通知消息
+type EventAdapter interface {
+	// EventType 返回规范化后的事件类型，无法识别时返回空字符串
+	EventType(in *inboundRequest) string
+	// Render 把事件渲染为通知，不支持的事件返回 errUnsupportedEvent
+	Render(eventType string, in *inboundRequest) (*notification, error)
+}
+
+var eventAdapters = map[string]EventAdapter{}
+
+func registerAdapter(name string, a EventAdapter) {
+	eventAdapters[name] = a
+}
+
+func adapterNames() []string {
+	names := make([]string, 0, len(eventAdapters))
+	for name := range eventAdapters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// notification 与 IM 平台无关的通知内容
+type notification struct {
+	Title string
+	Lines []string // 正文，每行一条，纯文本
+	URL   string   // 详情链接
+	// MarkdownLines 非空时 markdown 消息使用这些行代替 Lines，可包含链接等格式
+	MarkdownLines []string
+}
+
+// message 按消息类型渲染通知
+func (n *notification) message(t im.MessageType) (im.Message, error) {
+	switch t {
+	case im.TextMsg:
+		parts := append([]string{n.Title}, n.Lines...)
+		if n.URL != "" {
+			parts = append(parts, n.URL)
+		}
+		return im.Message{Type: t, Content: strings.Join(parts, "\n")}, nil
+	case im.MarkdownMsg:
+		var b strings.Builder
+		fmt.Fprintf(&b, "### %s\n", n.Title)
+		lines := n.Lines
+		if len(n.MarkdownLines) > 0 {
+			lines = n.MarkdownLines
+		}
+		for _, line := range lines {
+			fmt.Fprintf(&b, "> %s\n", line)
+		}
+		if n.URL != "" {
+			fmt.Fprintf(&b, "\n[查看详情](%s)", n.URL)
+		}
+		return im.Message{Type: t, Content: im.MarkdownContent{Content: b.String()}}, nil
+	case im.TextCardMsg:
+		return im.Message{Type: t, Content: im.TextCardContent{
+			Title:       n.Title,
+			Description: strings.Join(n.Lines, "\n"),
+			URL:         n.URL,
+			ButtonText:  "查看详情",
+		}}, nil
+	default:
+		return im.Message{}, fmt.Errorf("adapter does not support %q messages", t)
+	}
+}
+
+// truncate 按字符截断，超出部分以省略号代替
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n]) + "…"
+}
+
+// firstLine 取多行文本的第一行，用于提交信息等
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(line)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 告警事件类型，Events 过滤使用这些名称
+const (
+	alertFiring   = "firing"
+	alertResolved = "resolved"
+)
+
+// maxAlertLines 每种状态下最多列出的告警数
+const maxAlertLines = 10
+
+func init() {
+	registerAdapter("alertmanager", alertAdapter{})
+	registerAdapter("grafana", alertAdapter{grafana: true})
+}
+
+// alertPayload Alertmanager v4 Webhook 负载；Grafana 统一告警沿用该格式并增加了若干字段
+type alertPayload struct {
+	Version           string            `json:"version"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	TruncatedAlerts   int               `json:"truncatedAlerts"`
+	Alerts            []alert           `json:"alerts"`
+	// Grafana
+	Title   string `json:"title"`
+	Message string `json:"message"`
+}
+
+type alert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+	// Grafana
+	SilenceURL   string `json:"silenceURL"`
+	DashboardURL string `json:"dashboardURL"`
+	PanelURL     string `json:"panelURL"`
+	ValueString  string `json:"valueString"`
+}
+
+// alertAdapter 处理 Alertmanager 和 Grafana 告警，按状态分组渲染告警中与已恢复的摘要
+type alertAdapter struct {
+	grafana bool
+}
+
+func decodeAlerts(body []byte) (*alertPayload, error) {
+	var p alertPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, fmt.Errorf("decode payload: %w", err)
+	}
+	return &p, nil
+}
+
+// EventType 返回整组告警的状态：firing 或 resolved
+func (alertAdapter) EventType(in *inboundRequest) string {
+	p, err := decodeAlerts(in.Body)
+	if err != nil || len(p.Alerts) == 0 {
+		return ""
+	}
+	return p.Status
+}
+
+func (a alertAdapter) Render(eventType string, in *inboundRequest) (*notification, error) {
+	p, err := decodeAlerts(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.Alerts) == 0 {
+		return nil, errUnsupportedEvent
+	}
+
+	groups := map[string][]alert{}
+	for _, al := range p.Alerts {
+		groups[al.Status] = append(groups[al.Status], al)
+	}
+
+	var counts []string
+	if n := len(groups[alertFiring]); n > 0 {
+		counts = append(counts, fmt.Sprintf("告警中 %d", n))
+	}
+	if n := len(groups[alertResolved]); n > 0 {
+		counts = append(counts, fmt.Sprintf("已恢复 %d", n))
+	}
+
+	n := &notification{
+		Title: fmt.Sprintf("[%s] %s", strings.Join(counts, "，"), a.groupName(p)),
+		URL:   a.mainURL(p),
+	}
+	if summary := p.CommonAnnotations["summary"]; summary != "" {
+		n.Lines = append(n.Lines, summary)
+		n.MarkdownLines = append(n.MarkdownLines, summary)
+	}
+
+	for _, status := range []string{alertFiring, alertResolved} {
+		list := groups[status]
+		if len(list) == 0 {
+			continue
+		}
+		heading := "🔥 告警中"
+		if status == alertResolved {
+			heading = "✅ 已恢复"
+		}
+		n.Lines = append(n.Lines, heading)
+		n.MarkdownLines = append(n.MarkdownLines, "**"+heading+"**")
+		for i, al := range list {
+			if i == maxAlertLines {
+				more := fmt.Sprintf("…还有 %d 条", len(list)-maxAlertLines)
+				n.Lines = append(n.Lines, more)
+				n.MarkdownLines = append(n.MarkdownLines, more)
+				break
+			}
+			line := a.alertLine(p, al)
+			n.Lines = append(n.Lines, line)
+			n.MarkdownLines = append(n.MarkdownLines, line+a.alertLinks(p, al))
+		}
+	}
+	if p.TruncatedAlerts > 0 {
+		more := fmt.Sprintf("另有 %d 条告警被截断", p.TruncatedAlerts)
+		n.Lines = append(n.Lines, more)
+		n.MarkdownLines = append(n.MarkdownLines, more)
+	}
+	return n, nil
+}
+
+func (a alertAdapter) groupName(p *alertPayload) string {
+	if a.grafana && p.Title != "" {
+		// Grafana 的 title 已包含状态前缀，如 "[FIRING:1] ..."
+		if _, rest, ok := strings.Cut(p.Title, "] "); ok && strings.HasPrefix(p.Title, "[") {
+			return rest
+		}
+		return p.Title
+	}
+	if name := p.GroupLabels["alertname"]; name != "" {
+		return name
+	}
+	if name := p.CommonLabels["alertname"]; name != "" {
+		return name
+	}
+	return formatLabels(p.GroupLabels, nil)
+}
+
+func (a alertAdapter) mainURL(p *alertPayload) string {
+	if p.ExternalURL != "" {
+		return p.ExternalURL
+	}
+	for _, al := range p.Alerts {
+		for _, u := range []string{al.DashboardURL, al.GeneratorURL} {
+			if u != "" {
+				return u
+			}
+		}
+	}
+	return ""
+}
+
+// alertLine 单条告警摘要：公共标签以外的标签、描述以及开始或恢复时间
+func (a alertAdapter) alertLine(p *alertPayload, al alert) string {
+	var parts []string
+	if labels := formatLabels(al.Labels, p.CommonLabels); labels != "" {
+		parts = append(parts, labels)
+	}
+	desc := ""
+	for _, key := range []string{"summary", "description", "message"} {
+		if v := al.Annotations[key]; v != "" && v != p.CommonAnnotations["summary"] {
+			desc = v
+			break
+		}
+	}
+	if desc == "" && al.ValueString != "" {
+		desc = al.ValueString
+	}
+	if desc != "" {
+		parts = append(parts, truncate(firstLine(desc), 120))
+	}
+	if al.Status == alertResolved && !al.EndsAt.IsZero() {
+		parts = append(parts, "恢复于 "+al.EndsAt.Local().Format("01-02 15:04:05"))
+	} else if !al.StartsAt.IsZero() {
+		parts = append(parts, "开始于 "+al.StartsAt.Local().Format("01-02 15:04:05"))
+	}
+	return "- " + strings.Join(parts, "，")
+}
+
+// alertLinks 告警来源与静默链接，仅在 markdown 中展示
+func (a alertAdapter) alertLinks(p *alertPayload, al alert) string {
+	var links []string
+	if al.GeneratorURL != "" {
+		links = append(links, fmt.Sprintf("[来源](%s)", al.GeneratorURL))
+	}
+	if al.PanelURL != "" {
+		links = append(links, fmt.Sprintf("[面板](%s)", al.PanelURL))
+	}
+	if al.Status == alertFiring {
+		if u := a.silenceURL(p, al); u != "" {
+			links = append(links, fmt.Sprintf("[静默](%s)", u))
+		}
+	}
+	if len(links) == 0 {
+		return ""
+	}
+	return " " + strings.Join(links, " · ")
+}
+
+// silenceURL Grafana 直接提供静默链接；Alertmanager 按告警标签拼出新建静默页面的地址
+func (a alertAdapter) silenceURL(p *alertPayload, al alert) string {
+	if al.SilenceURL != "" {
+		return al.SilenceURL
+	}
+	if a.grafana || p.ExternalURL == "" || len(al.Labels) == 0 {
+		return ""
+	}
+	keys := sortedKeys(al.Labels)
+	matchers := make([]string, 0, len(keys))
+	for _, k := range keys {
+		matchers = append(matchers, fmt.Sprintf("%s=%q", k, al.Labels[k]))
+	}
+	filter := "{" + strings.Join(matchers, ",") + "}"
+	return strings.TrimSuffix(p.ExternalURL, "/") + "/#/silences/new?filter=" + url.QueryEscape(filter)
+}
+
+// formatLabels 按键排序输出 k=v，跳过 alertname 以及与 common 中相同的标签
+func formatLabels(labels, common map[string]string) string {
+	var parts []string
+	for _, k := range sortedKeys(labels) {
+		if k == "alertname" || (common != nil && common[k] == labels[k]) {
+			continue
+		}
+		parts = append(parts, k+"="+labels[k])
+	}
+	return strings.Join(parts, " ")
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// 代码托管平台事件类型，Events 过滤使用这些名称
+const (
+	gitEventPush        = "push"
+	gitEventTagPush     = "tag_push"
+	gitEventPullRequest = "pull_request"
+	gitEventIssues      = "issues"
+	gitEventRelease     = "release"
+	gitEventPipeline    = "pipeline"
+)
+
+// maxCommitLines 推送通知中最多列出的提交数
+const maxCommitLines = 5
+
+func init() {
+	registerAdapter("github", githubAdapter{header: "X-GitHub-Event"})
+	registerAdapter("gitea", githubAdapter{header: "X-Gitea-Event"})
+	registerAdapter("gitlab", gitlabAdapter{})
+	registerAdapter("git", gitAutoAdapter{})
+}
+
+type gitUser struct {
+	Login    string `json:"login"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+}
+
+func (u gitUser) display() string {
+	for _, s := range []string{u.Login, u.Username, u.Name} {
+		if s != "" {
+			return s
+		}
+	}
+	return "unknown"
+}
+
+type gitCommit struct {
+	ID      string  `json:"id"`
+	Message string  `json:"message"`
+	URL     string  `json:"url"`
+	Author  gitUser `json:"author"`
+}
+
+// commitLines 渲染提交列表，超出部分汇总为一行
+func commitLines(commits []gitCommit, total int) []string {
+	var lines []string
+	for i, c := range commits {
+		if i == maxCommitLines {
+			break
+		}
+		id := c.ID
+		if len(id) > 8 {
+			id = id[:8]
+		}
+		lines = append(lines, fmt.Sprintf("%s %s - %s", id, truncate(firstLine(c.Message), 80), c.Author.display()))
+	}
+	if total < len(commits) {
+		total = len(commits)
+	}
+	if total > maxCommitLines {
+		lines = append(lines, fmt.Sprintf("…还有 %d 个提交", total-maxCommitLines))
+	}
+	return lines
+}
+
+// refName 去掉 refs/heads/、refs/tags/ 前缀
+func refName(ref string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(ref, "refs/heads/"), "refs/tags/")
+}
+
+var gitActionNames = map[string]string{
+	"opened":       "创建了",
+	"open":         "创建了",
+	"closed":       "关闭了",
+	"close":        "关闭了",
+	"reopened":     "重新打开了",
+	"reopen":       "重新打开了",
+	"edited":       "编辑了",
+	"update":       "更新了",
+	"synchronize":  "更新了",
+	"synchronized": "更新了",
+	"merged":       "合并了",
+	"merge":        "合并了",
+	"approved":     "批准了",
+	"published":    "发布了",
+	"created":      "发布了",
+	"create":       "发布了",
+	"released":     "发布了",
+	"deleted":      "删除了",
+	"delete":       "删除了",
+}
+
+func gitAction(action string) string {
+	if name, ok := gitActionNames[action]; ok {
+		return name
+	}
+	return action + " "
+}
+
+// githubAdapter 处理 GitHub 事件；Gitea 的负载与 GitHub 基本兼容，共用同一实现
+type githubAdapter struct {
+	header string
+}
+
+type githubPayload struct {
+	Action     string      `json:"action"`
+	Ref        string      `json:"ref"`
+	Compare    string      `json:"compare"`
+	CompareURL string      `json:"compare_url"` // Gitea
+	Created    bool        `json:"created"`
+	Deleted    bool        `json:"deleted"`
+	Commits    []gitCommit `json:"commits"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		HTMLURL  string `json:"html_url"`
+	} `json:"repository"`
+	Pusher      gitUser `json:"pusher"`
+	Sender      gitUser `json:"sender"`
+	Number      int     `json:"number"`
+	PullRequest *struct {
+		Title   string  `json:"title"`
+		HTMLURL string  `json:"html_url"`
+		User    gitUser `json:"user"`
+		Merged  bool    `json:"merged"`
+		Head    struct {
+			Ref string `json:"ref"`
+		} `json:"head"`
+		Base struct {
+			Ref string `json:"ref"`
+		} `json:"base"`
+	} `json:"pull_request"`
+	Issue *struct {
+		Number  int     `json:"number"`
+		Title   string  `json:"title"`
+		HTMLURL string  `json:"html_url"`
+		User    gitUser `json:"user"`
+	} `json:"issue"`
+	Release *struct {
+		TagName string  `json:"tag_name"`
+		Name    string  `json:"name"`
+		HTMLURL string  `json:"html_url"`
+		Body    string  `json:"body"`
+		Author  gitUser `json:"author"`
+	} `json:"release"`
+	WorkflowRun *struct {
+		Name       string `json:"name"`
+		HeadBranch string `json:"head_branch"`
+		Status     string `json:"status"`
+		Conclusion string `json:"conclusion"`
+		HTMLURL    string `json:"html_url"`
+		RunNumber  int    `json:"run_number"`
+	} `json:"workflow_run"`
+}
+
+func (a githubAdapter) EventType(in *inboundRequest) string {
+	switch event := in.Header.Get(a.header); event {
+	case "push":
+		if strings.HasPrefix(payloadRef(in.Body), "refs/tags/") {
+			return gitEventTagPush
+		}
+		return gitEventPush
+	case "workflow_run":
+		return gitEventPipeline
+	default:
+		return event
+	}
+}
+
+// payloadRef 读取负载中的 ref 字段，用于区分分支推送和标签推送
+func payloadRef(body []byte) string {
+	var p struct {
+		Ref string `json:"ref"`
+	}
+	json.Unmarshal(body, &p)
+	return p.Ref
+}
+
+func (a githubAdapter) Render(eventType string, in *inboundRequest) (*notification, error) {
+	var p githubPayload
+	if err := json.Unmarshal(in.Body, &p); err != nil {
+		return nil, fmt.Errorf("decode payload: %w", err)
+	}
+	repo := p.Repository.FullName
+
+	switch eventType {
+	case gitEventPush, gitEventTagPush:
+		who := p.Pusher.display()
+		if who == "unknown" {
+			who = p.Sender.display()
+		}
+		n := &notification{URL: p.Compare}
+		if n.URL == "" {
+			n.URL = p.CompareURL
+		}
+		if n.URL == "" {
+			n.URL = p.Repository.HTMLURL
+		}
+		ref := refName(p.Ref)
+		kind := "分支"
+		if eventType == gitEventTagPush {
+			kind = "标签"
+		}
+		switch {
+		case p.Deleted:
+			n.Title = fmt.Sprintf("[%s] %s 删除了%s %s", repo, who, kind, ref)
+		case eventType == gitEventTagPush:
+			n.Title = fmt.Sprintf("[%s] %s 推送了标签 %s", repo, who, ref)
+		default:
+			n.Title = fmt.Sprintf("[%s] %s 推送了 %d 个提交到 %s", repo, who, len(p.Commits), ref)
+			n.Lines = commitLines(p.Commits, len(p.Commits))
+		}
+		return n, nil
+
+	case gitEventPullRequest:
+		if p.PullRequest == nil {
+			return nil, errUnsupportedEvent
+		}
+		pr := p.PullRequest
+		action := p.Action
+		if action == "closed" && pr.Merged {
+			action = "merged"
+		}
+		return &notification{
+			Title: fmt.Sprintf("[%s] %s %s合并请求 #%d：%s", repo, p.Sender.display(), gitAction(action), p.Number, pr.Title),
+			Lines: []string{fmt.Sprintf("%s → %s", pr.Head.Ref, pr.Base.Ref), "作者：" + pr.User.display()},
+			URL:   pr.HTMLURL,
+		}, nil
+
+	case gitEventIssues:
+		if p.Issue == nil {
+			return nil, errUnsupportedEvent
+		}
+		return &notification{
+			Title: fmt.Sprintf("[%s] %s %s议题 #%d：%s", repo, p.Sender.display(), gitAction(p.Action), p.Issue.Number, p.Issue.Title),
+			URL:   p.Issue.HTMLURL,
+		}, nil
+
+	case gitEventRelease:
+		if p.Release == nil {
+			return nil, errUnsupportedEvent
+		}
+		rel := p.Release
+		n := &notification{
+			Title: fmt.Sprintf("[%s] %s %s版本 %s", repo, p.Sender.display(), gitAction(p.Action), rel.TagName),
+			URL:   rel.HTMLURL,
+		}
+		if rel.Name != "" && rel.Name != rel.TagName {
+			n.Lines = append(n.Lines, rel.Name)
+		}
+		if body := firstLine(rel.Body); body != "" {
+			n.Lines = append(n.Lines, truncate(body, 120))
+		}
+		return n, nil
+
+	case gitEventPipeline:
+		if p.WorkflowRun == nil {
+			return nil, errUnsupportedEvent
+		}
+		run := p.WorkflowRun
+		status := run.Conclusion
+		if status == "" {
+			status = run.Status
+		}
+		return &notification{
+			Title: fmt.Sprintf("[%s] 工作流 %s #%d：%s", repo, run.Name, run.RunNumber, status),
+			Lines: []string{"分支：" + run.HeadBranch},
+			URL:   run.HTMLURL,
+		}, nil
+	}
+	return nil, errUnsupportedEvent
+}
+
+// gitlabAdapter 处理 GitLab 事件
+type gitlabAdapter struct{}
+
+var gitlabEvents = map[string]string{
+	"Push Hook":               gitEventPush,
+	"Tag Push Hook":           gitEventTagPush,
+	"Merge Request Hook":      gitEventPullRequest,
+	"Issue Hook":              gitEventIssues,
+	"Confidential Issue Hook": gitEventIssues,
+	"Release Hook":            gitEventRelease,
+	"Pipeline Hook":           gitEventPipeline,
+}
+
+type gitlabPayload struct {
+	Ref               string      `json:"ref"`
+	Before            string      `json:"before"`
+	After             string      `json:"after"`
+	UserName          string      `json:"user_name"`
+	TotalCommitsCount int         `json:"total_commits_count"`
+	Commits           []gitCommit `json:"commits"`
+	User              gitUser     `json:"user"`
+	Project           struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+		WebURL            string `json:"web_url"`
+	} `json:"project"`
+	ObjectAttributes struct {
+		ID           int     `json:"id"`
+		IID          int     `json:"iid"`
+		Title        string  `json:"title"`
+		URL          string  `json:"url"`
+		Action       string  `json:"action"`
+		State        string  `json:"state"`
+		SourceBranch string  `json:"source_branch"`
+		TargetBranch string  `json:"target_branch"`
+		Ref          string  `json:"ref"`
+		Status       string  `json:"status"`
+		Duration     float64 `json:"duration"`
+	} `json:"object_attributes"`
+	// Release Hook 的字段位于顶层
+	Action string `json:"action"`
+	Name   string `json:"name"`
+	Tag    string `json:"tag"`
+	URL    string `json:"url"`
+	Commit struct {
+		Message string `json:"message"`
+	} `json:"commit"`
+}
+
+// gitlabZeroSHA 分支被删除时 after 为全零
+const gitlabZeroSHA = "0000000000000000000000000000000000000000"
+
+func (gitlabAdapter) EventType(in *inboundRequest) string {
+	return gitlabEvents[in.Header.Get("X-Gitlab-Event")]
+}
+
+func (gitlabAdapter) Render(eventType string, in *inboundRequest) (*notification, error) {
+	var p gitlabPayload
+	if err := json.Unmarshal(in.Body, &p); err != nil {
+		return nil, fmt.Errorf("decode payload: %w", err)
+	}
+	project := p.Project.PathWithNamespace
+	who := p.UserName
+	if who == "" {
+		who = p.User.display()
+	}
+	attrs := p.ObjectAttributes
+
+	switch eventType {
+	case gitEventPush, gitEventTagPush:
+		ref := refName(p.Ref)
+		n := &notification{URL: p.Project.WebURL}
+		switch {
+		case p.After == gitlabZeroSHA && eventType == gitEventTagPush:
+			n.Title = fmt.Sprintf("[%s] %s 删除了标签 %s", project, who, ref)
+		case p.After == gitlabZeroSHA:
+			n.Title = fmt.Sprintf("[%s] %s 删除了分支 %s", project, who, ref)
+		case eventType == gitEventTagPush:
+			n.Title = fmt.Sprintf("[%s] %s 推送了标签 %s", project, who, ref)
+		default:
+			total := max(p.TotalCommitsCount, len(p.Commits))
+			n.Title = fmt.Sprintf("[%s] %s 推送了 %d 个提交到 %s", project, who, total, ref)
+			n.Lines = commitLines(p.Commits, total)
+			if p.Before != gitlabZeroSHA && p.Before != "" {
+				n.URL = fmt.Sprintf("%s/-/compare/%s...%s", p.Project.WebURL, p.Before, p.After)
+			}
+		}
+		return n, nil
+
+	case gitEventPullRequest:
+		action := attrs.Action
+		if action == "" {
+			action = attrs.State
+		}
+		return &notification{
+			Title: fmt.Sprintf("[%s] %s %s合并请求 !%d：%s", project, who, gitAction(action), attrs.IID, attrs.Title),
+			Lines: []string{fmt.Sprintf("%s → %s", attrs.SourceBranch, attrs.TargetBranch)},
+			URL:   attrs.URL,
+		}, nil
+
+	case gitEventIssues:
+		action := attrs.Action
+		if action == "" {
+			action = attrs.State
+		}
+		return &notification{
+			Title: fmt.Sprintf("[%s] %s %s议题 #%d：%s", project, who, gitAction(action), attrs.IID, attrs.Title),
+			URL:   attrs.URL,
+		}, nil
+
+	case gitEventRelease:
+		n := &notification{
+			Title: fmt.Sprintf("[%s] %s版本 %s", project, gitAction(p.Action), p.Tag),
+			URL:   p.URL,
+		}
+		if p.Name != "" && p.Name != p.Tag {
+			n.Lines = append(n.Lines, p.Name)
+		}
+		return n, nil
+
+	case gitEventPipeline:
+		n := &notification{
+			Title: fmt.Sprintf("[%s] 流水线 #%d：%s", project, attrs.ID, attrs.Status),
+			Lines: []string{"分支：" + attrs.Ref, "触发人：" + who},
+			URL:   attrs.URL,
+		}
+		if msg := firstLine(p.Commit.Message); msg != "" {
+			n.Lines = append(n.Lines, "提交："+truncate(msg, 80))
+		}
+		if attrs.Duration > 0 {
+			n.Lines = append(n.Lines, fmt.Sprintf("耗时：%.0f 秒", attrs.Duration))
+		}
+		if n.URL == "" {
+			n.URL = fmt.Sprintf("%s/-/pipelines/%d", p.Project.WebURL, attrs.ID)
+		}
+		return n, nil
+	}
+	return nil, errUnsupportedEvent
+}
+
+// gitAutoAdapter 根据请求头自动识别 Gitea、GitLab 或 GitHub。
+// Gitea 同时会发送 X-GitHub-Event，因此需要最先检查
+type gitAutoAdapter struct{}
+
+func (gitAutoAdapter) detect(in *inboundRequest) EventAdapter {
+	switch {
+	case in.Header.Get("X-Gitea-Event") != "":
+		return eventAdapters["gitea"]
+	case in.Header.Get("X-Gitlab-Event") != "":
+		return eventAdapters["gitlab"]
+	case in.Header.Get("X-GitHub-Event") != "":
+		return eventAdapters["github"]
+	}
+	return nil
+}
+
+func (a gitAutoAdapter) EventType(in *inboundRequest) string {
+	if d := a.detect(in); d != nil {
+		return d.EventType(in)
+	}
+	return ""
+}
+
+func (a gitAutoAdapter) Render(eventType string, in *inboundRequest) (*notification, error) {
+	if d := a.detect(in); d != nil {
+		return d.Render(eventType, in)
+	}
+	return nil, errUnsupportedEvent
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//go:embed api/openapi.yaml
+var openAPISpec []byte
+
+// redacted 接口返回中代替密钥的占位符，更新时传回占位符表示保留原值
+const redacted = "******"
+
+// apiError 错误响应：{"error":{"code":"not_found","message":"..."}}
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, struct {
+		Error apiError `json:"error"`
+	}{apiError{code, message}})
+}
+
+// hookRequest 创建与更新 Hook 的请求体。更新时只修改非空字段
+type hookRequest struct {
+	Targets   []Target       `json:"targets"`
+	Forward   *ForwardConfig `json:"forward"`
+	Transform *Transform     `json:"transform"`
+	IM        *IMTarget      `json:"im"`
+	Verify    *Verification  `json:"verify"`
+	Signing   *Signing       `json:"signing"` // secret 为空时自动生成
+	Retry     *RetryPolicy   `json:"retry"`
+	Retention *Retention     `json:"retention"`
+	Limits    *Limits        `json:"limits"`
+	Filter    *Filter        `json:"filter"` // 传入 {} 清除条件
+	// ClientCert required 为 false 时取消客户端证书要求
+	ClientCert *ClientCertPolicy `json:"client_cert"`
+	Paused     *bool             `json:"paused"`
+	Team       *string           `json:"team"`
+}
+
+// errNoStoredSecret 请求中的密钥为占位符，但 Hook 没有可以保留的原值
+var errNoStoredSecret = errors.New("secret " + redacted + " is a placeholder for an existing secret, but none is stored")
+
+// apply 把请求中的字段写入 hook，密钥为占位符时保留原值，没有原值时返回错误
+func (req *hookRequest) apply(hook *Hook) error {
+	if req.Targets != nil {
+		hook.Targets = req.Targets
+	}
+	if req.Forward != nil {
+		hook.Forward = *req.Forward
+	}
+	if req.Transform != nil {
+		hook.Transform = req.Transform
+	}
+	if req.IM != nil {
+		if req.IM.AppSecret == redacted {
+			if hook.IM == nil {
+				return fmt.Errorf("im: %w", errNoStoredSecret)
+			}
+			req.IM.AppSecret = hook.IM.AppSecret
+		}
+		hook.IM = req.IM
+	}
+	if req.Verify != nil {
+		if req.Verify.Secret == redacted {
+			if hook.Verify == nil {
+				return fmt.Errorf("verify: %w", errNoStoredSecret)
+			}
+			req.Verify.Secret = hook.Verify.Secret
+		}
+		hook.Verify = req.Verify
+	}
+	if req.Signing != nil {
+		switch {
+		case req.Signing.Secret == redacted:
+			// 保持原有密钥，轮换使用 /signing/{id}/rotate
+			if hook.Signing == nil {
+				return fmt.Errorf("signing: %w", errNoStoredSecret)
+			}
+		case req.Signing.Secret == "":
+			hook.Signing = &Signing{Secret: newSigningSecret()}
+		default:
+			hook.Signing = &Signing{Secret: req.Signing.Secret}
+		}
+	}
+	if req.Retry != nil {
+		hook.Retry = req.Retry
+	}
+	if req.Retention != nil {
+		hook.Retention = *req.Retention
+	}
+	if req.Limits != nil {
+		hook.Limits = *req.Limits
+	}
+	if req.Filter != nil {
+		hook.Filter = req.Filter
+		if req.Filter.empty() {
+			hook.Filter = nil
+		}
+	}
+	if req.ClientCert != nil {
+		hook.ClientCert = req.ClientCert
+		if !req.ClientCert.Required {
+			hook.ClientCert = nil
+		}
+	}
+	if req.Paused != nil {
+		hook.Paused = *req.Paused
+	}
+	if req.Team != nil {
+		hook.Team = strings.TrimSpace(*req.Team)
+	}
+	return nil
+}
+
+// validate 检查 Hook 配置是否可用，HTML 表单与 JSON API 共用的规则见各配置的 Validate
+func (h *Hook) validate() error {
+	if h.IM != nil {
+		if len(h.Targets) > 0 {
+			return errors.New("im hooks must not have targets")
+		}
+		if err := h.IM.Validate(); err != nil {
+			return fmt.Errorf("im: %w", err)
+		}
+	} else if len(h.Targets) == 0 {
+		return errors.New("at least one target is required")
+	}
+	for _, t := range h.Targets {
+		if err := checkTargetURL(t.URL); err != nil {
+			return err
+		}
+	}
+	if h.Transform != nil {
+		if err := h.Transform.Validate(); err != nil {
+			return fmt.Errorf("transform: %w", err)
+		}
+	}
+	if h.Verify != nil {
+		if err := h.Verify.Validate(); err != nil {
+			return fmt.Errorf("verify: %w", err)
+		}
+	}
+	if h.Signing != nil {
+		if err := h.Signing.Validate(); err != nil {
+			return fmt.Errorf("signing: %w", err)
+		}
+	}
+	if h.Retry != nil && (h.Retry.MaxRetries < 0 || h.Retry.BaseDelay < 0 || h.Retry.MaxDelay < 0) {
+		return errors.New("retry: values must not be negative")
+	}
+	if h.Retention.MaxCount < 0 || h.Retention.MaxAge < 0 {
+		return errors.New("retention: values must not be negative")
+	}
+	if err := h.Limits.Validate(); err != nil {
+		return fmt.Errorf("limits: %w", err)
+	}
+	if h.Filter != nil {
+		if err := h.Filter.Validate(); err != nil {
+			return fmt.Errorf("filter: %w", err)
+		}
+	}
+	if h.ClientCert != nil && !clientCAConfigured {
+		return errors.New("client_cert: requires the server to run with tls.cert_file, tls.key_file and tls.client_ca_file")
+	}
+	return nil
+}
+
+// checkTargetURL 投递目标必须是带主机名的 http 或 https 地址
+func checkTargetURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid target url %q", raw)
+	}
+	return nil
+}
+
+// apiHook 接口返回的 Hook，密钥以占位符代替
+type apiHook struct {
+	*Hook
+	URL string `json:"url"` // 入站地址
+}
+
+func newAPIHook(h *Hook) apiHook {
+	c := *h
+	c.TokenHash = ""
+	if c.IM != nil {
+		im := *c.IM
+		im.AppSecret = redacted
+		c.IM = &im
+	}
+	if c.Verify != nil {
+		v := *c.Verify
+		v.Secret = redacted
+		c.Verify = &v
+	}
+	if c.Signing != nil {
+		s := Signing{Secret: redacted, PreviousUntil: c.Signing.PreviousUntil}
+		if c.Signing.PreviousSecret != "" {
+			s.PreviousSecret = redacted
+		}
+		c.Signing = &s
+	}
+	return apiHook{Hook: &c, URL: "/hook/" + h.ID}
+}
+
+// apiHandler 版本化的 JSON 管理接口，详见 api/openapi.yaml：
+//
+//	GET    /api/v1/openapi.yaml
+//	GET    /api/v1/hooks
+//	POST   /api/v1/hooks
+//	GET    /api/v1/hooks/{id}
+//	PATCH  /api/v1/hooks/{id}
+//	DELETE /api/v1/hooks/{id}
+//	GET    /api/v1/hooks/{id}/logs
+//	GET    /api/v1/hooks/{id}/logs/{logID}
+//	GET    /api/v1/users
+//	POST   /api/v1/users
+//	GET    /api/v1/users/me
+//	GET    /api/v1/users/me/keys
+//	POST   /api/v1/users/me/keys
+//	DELETE /api/v1/users/me/keys/{keyID}
+//
+// 请求需要在 Authorization: Bearer 中携带用户的 API Key；针对单个 Hook 的操作也可以使用该 Hook 的管理令牌
+func apiHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1"), "/")
+	if path == "openapi.yaml" {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(openAPISpec)
+		return
+	}
+	parts := strings.Split(path, "/")
+	if parts[0] == "users" {
+		apiUsersHandler(w, r, parts[1:])
+		return
+	}
+	if parts[0] != "hooks" {
+		writeAPIError(w, http.StatusNotFound, "not_found", "unknown endpoint")
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		switch r.Method {
+		case http.MethodGet:
+			apiListHooks(w, r)
+		case http.MethodPost:
+			apiCreateHook(w, r)
+		default:
+			methodNotAllowed(w, "GET, POST")
+		}
+	case len(parts) == 2:
+		switch r.Method {
+		case http.MethodGet:
+			if hook := apiAuthorize(w, r, parts[1]); hook != nil {
+				writeJSON(w, http.StatusOK, newAPIHook(hook))
+			}
+		case http.MethodPatch:
+			apiUpdateHook(w, r, parts[1])
+		case http.MethodDelete:
+			apiDeleteHook(w, r, parts[1])
+		default:
+			methodNotAllowed(w, "GET, PATCH, DELETE")
+		}
+	case parts[2] == "logs" && len(parts) <= 4:
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, "GET")
+			return
+		}
+		if apiAuthorize(w, r, parts[1]) == nil {
+			return
+		}
+		if len(parts) == 4 {
+			apiGetLog(w, parts[1], parts[3])
+		} else {
+			apiQueryLogs(w, r, parts[1])
+		}
+	default:
+		writeAPIError(w, http.StatusNotFound, "not_found", "unknown endpoint")
+	}
+}
+
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+}
+
+// apiAuthorize 与 authorizeHook 相同，但以 JSON 格式返回错误
+func apiAuthorize(w http.ResponseWriter, r *http.Request, id string) *Hook {
+	hook, status, err := hookAccess(r, id)
+	switch status {
+	case http.StatusOK:
+		return hook
+	case http.StatusNotFound:
+		writeAPIError(w, status, "not_found", "hook not found")
+	case http.StatusUnauthorized:
+		w.Header().Set("WWW-Authenticate", `Bearer realm="webhook-proxy"`)
+		writeAPIError(w, status, "unauthorized", "api key or management token required")
+	case http.StatusForbidden:
+		writeAPIError(w, status, "forbidden", "no access to this hook")
+	default:
+		writeAPIError(w, status, "internal", err.Error())
+	}
+	return nil
+}
+
+// apiUser 要求请求携带有效的 API Key 或登录会话
+func apiUser(w http.ResponseWriter, r *http.Request) *User {
+	u := currentUser(r)
+	if u == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="webhook-proxy"`)
+		writeAPIError(w, http.StatusUnauthorized, "unauthorized", "api key required")
+	}
+	return u
+}
+
+func decodeHookRequest(w http.ResponseWriter, r *http.Request) (*hookRequest, bool) {
+	var req hookRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "decode body: "+err.Error())
+		return nil, false
+	}
+	return &req, true
+}
+
+// apiListHooks 列出当前用户可以访问的 Hook，管理员可以看到全部
+func apiListHooks(w http.ResponseWriter, r *http.Request) {
+	user := apiUser(w, r)
+	if user == nil {
+		return
+	}
+	hooks, err := store.ListHooks()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	list := make([]apiHook, 0, len(hooks))
+	for _, h := range hooks {
+		if user.canAccess(h) {
+			list = append(list, newAPIHook(h))
+		}
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Hooks []apiHook `json:"hooks"`
+	}{list})
+}
+
+func apiCreateHook(w http.ResponseWriter, r *http.Request) {
+	user := apiUser(w, r)
+	if user == nil {
+		return
+	}
+	req, ok := decodeHookRequest(w, r)
+	if !ok {
+		return
+	}
+	token, tokenHash := newManagementToken()
+	hook := &Hook{ID: newHookID(), Owner: user.Name, TokenHash: tokenHash, CreatedAt: time.Now()}
+	if err := req.apply(hook); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if err := checkTeam(user, hook.Team); err != nil {
+		writeAPIError(w, http.StatusForbidden, "forbidden", err.Error())
+		return
+	}
+	if err := hook.validate(); err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, "invalid_hook", err.Error())
+		return
+	}
+	if err := store.CreateHook(hook); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+
+	// 管理令牌与签名密钥只在创建时返回明文
+	resp := struct {
+		apiHook
+		Token         string `json:"token"`
+		SigningSecret string `json:"signing_secret,omitempty"`
+	}{apiHook: newAPIHook(hook), Token: token}
+	if hook.Signing != nil {
+		resp.SigningSecret = hook.Signing.Secret
+	}
+	w.Header().Set("Location", "/api/v1/hooks/"+hook.ID)
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+func apiUpdateHook(w http.ResponseWriter, r *http.Request, id string) {
+	hook := apiAuthorize(w, r, id)
+	if hook == nil {
+		return
+	}
+	req, ok := decodeHookRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.IM != nil && req.Targets == nil {
+		hook.Targets = nil
+	}
+	if req.Targets != nil && req.IM == nil {
+		hook.IM = nil
+	}
+	var oldSecret string
+	if hook.Signing != nil {
+		oldSecret = hook.Signing.Secret
+	}
+	if err := req.apply(hook); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if req.Team != nil {
+		// 只持有管理令牌的调用方不能修改团队，否则可以把 Hook 交给任意团队
+		u := currentUser(r)
+		if u == nil {
+			writeAPIError(w, http.StatusForbidden, "forbidden", "changing the team requires a signed-in user or API key")
+			return
+		}
+		if err := checkTeam(u, hook.Team); err != nil {
+			writeAPIError(w, http.StatusForbidden, "forbidden", err.Error())
+			return
+		}
+	}
+	if err := hook.validate(); err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, "invalid_hook", err.Error())
+		return
+	}
+	if err := store.UpdateHook(hook); errors.Is(err, ErrHookNotFound) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "hook not found")
+		return
+	} else if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	// 新设置或自动生成的签名密钥只在这次响应中返回明文
+	resp := struct {
+		apiHook
+		SigningSecret string `json:"signing_secret,omitempty"`
+	}{apiHook: newAPIHook(hook)}
+	if hook.Signing != nil && hook.Signing.Secret != oldSecret {
+		resp.SigningSecret = hook.Signing.Secret
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func apiDeleteHook(w http.ResponseWriter, r *http.Request, id string) {
+	if apiAuthorize(w, r, id) == nil {
+		return
+	}
+	if err := store.DeleteHook(id); err != nil && !errors.Is(err, ErrHookNotFound) {
+		writeAPIError(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	forgetIMClient(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiQueryLogs(w http.ResponseWriter, r *http.Request, id string) {
+	q, err := parseLogQuery(r.URL.Query())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	logs, next, err := store.QueryLogs(id, q)
+	switch {
+	case errors.Is(err, ErrHookNotFound):
+		writeAPIError(w, http.StatusNotFound, "not_found", "hook not found")
+	case errors.Is(err, ErrInvalidCursor):
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "invalid cursor")
+	case err != nil:
+		writeAPIError(w, http.StatusInternalServerError, "internal", err.Error())
+	default:
+		writeJSON(w, http.StatusOK, struct {
+			Logs       []Log  `json:"logs"`
+			NextCursor string `json:"next_cursor,omitempty"`
+		}{logs, next})
+	}
+}
+
+func apiGetLog(w http.ResponseWriter, hookID, logID string) {
+	entry, err := store.GetLog(hookID, logID)
+	switch {
+	case errors.Is(err, ErrHookNotFound) || errors.Is(err, ErrLogNotFound):
+		writeAPIError(w, http.StatusNotFound, "not_found", "log not found")
+	case err != nil:
+		writeAPIError(w, http.StatusInternalServerError, "internal", err.Error())
+	default:
+		writeJSON(w, http.StatusOK, entry)
+	}
+}
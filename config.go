@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config 服务配置。优先级从低到高：默认值、配置文件、环境变量、命令行参数。
+// 每个命令行参数都有对应的环境变量，如 -queue-size 对应 WEBHOOK_PROXY_QUEUE_SIZE
+type Config struct {
+	Listen    string        `yaml:"listen"`
+	StaticDir string        `yaml:"static_dir"`
+	Timeouts  TimeoutConfig `yaml:"timeouts"`
+	TLS       TLSConfig     `yaml:"tls"`
+	Store     StoreConfig   `yaml:"store"`
+	Queue     QueueConfig   `yaml:"queue"`
+	Retry     RetryPolicy   `yaml:"retry"`
+	Retention Retention     `yaml:"retention"`
+	Limits    Limits        `yaml:"limits"`
+	Log       LogConfig     `yaml:"log"`
+	// MetricsToken 抓取 /metrics 使用的 Bearer Token，为空时只有管理员的 API Key 可以访问
+	MetricsToken string `yaml:"metrics_token"`
+	// Hooks 预定义的 Hook，启动时创建或更新为配置中的内容
+	Hooks []configHook `yaml:"hooks"`
+}
+
+// TimeoutConfig HTTP 服务器超时。Shutdown 为收到 SIGINT/SIGTERM 后等待请求与投递完成的时间
+type TimeoutConfig struct {
+	ReadHeader time.Duration `yaml:"read_header"`
+	Read       time.Duration `yaml:"read"`
+	Write      time.Duration `yaml:"write"`
+	Idle       time.Duration `yaml:"idle"`
+	Shutdown   time.Duration `yaml:"shutdown"`
+}
+
+// TLSConfig 同时设置证书和私钥时启用 HTTPS，见 certReloader
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientCAFile 签发客户端证书的 CA，设置了 client_cert 的 Hook 据此校验调用方
+	ClientCAFile string `yaml:"client_ca_file"`
+	// ReloadInterval 检查证书文件是否变化的间隔，0 表示只在收到 SIGHUP 时重新加载
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+}
+
+type StoreConfig struct {
+	Kind string `yaml:"kind"` // memory 或 file
+	Path string `yaml:"path"` // file 存储的数据文件路径
+}
+
+type QueueConfig struct {
+	Workers int `yaml:"workers"`
+	Size    int `yaml:"size"`
+}
+
+// configHook 字段与 JSON API 的 HookRequest 相同，另外可以指定 ID、所有者和管理令牌
+type configHook struct {
+	ID    string `json:"id"`
+	Owner string `json:"owner"`
+	Token string `json:"token"` // 管理令牌明文，只保存哈希
+	hookRequest
+}
+
+// UnmarshalYAML 先转为 JSON 再按 API 的字段解码，两处的写法保持一致。
+// 时长与顶层配置一样可以写作 1s、72h，整数仍按纳秒处理
+func (h *configHook) UnmarshalYAML(node *yaml.Node) error {
+	var v map[string]interface{}
+	if err := node.Decode(&v); err != nil {
+		return err
+	}
+	if err := parseDurations(v, reflect.TypeOf(h).Elem()); err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(h); err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// parseDurations 按 t 的 JSON 字段找到 v 中的 time.Duration 字段，把字符串解析为纳秒
+func parseDurations(v interface{}, t reflect.Type) error {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Anonymous {
+				if err := parseDurations(m, f.Type); err != nil {
+					return err
+				}
+				continue
+			}
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			val, ok := m[name]
+			if name == "" || name == "-" || !ok {
+				continue
+			}
+			if s, isString := val.(string); isString && f.Type == durationType {
+				d, err := time.ParseDuration(s)
+				if err != nil {
+					return fmt.Errorf("%s: %w", name, err)
+				}
+				m[name] = int64(d)
+				continue
+			}
+			if err := parseDurations(val, f.Type); err != nil {
+				return fmt.Errorf("%s.%w", name, err)
+			}
+		}
+	case reflect.Slice:
+		items, _ := v.([]interface{})
+		for i, item := range items {
+			if err := parseDurations(item, t.Elem()); err != nil {
+				return fmt.Errorf("%d.%w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// envPrefix 命令行参数对应的环境变量前缀
+const envPrefix = "WEBHOOK_PROXY_"
+
+func defaultConfig() Config {
+	return Config{
+		Listen:    ":8080",
+		StaticDir: "static",
+		Timeouts: TimeoutConfig{
+			ReadHeader: 10 * time.Second,
+			Read:       30 * time.Second,
+			Write:      30 * time.Second,
+			Idle:       2 * time.Minute,
+			Shutdown:   30 * time.Second,
+		},
+		TLS:       TLSConfig{ReloadInterval: 30 * time.Second},
+		Store:     StoreConfig{Kind: "file", Path: "data/hooks.json"},
+		Queue:     QueueConfig{Workers: 4, Size: 1000},
+		Retry:     RetryPolicy{MaxRetries: 5, BaseDelay: time.Second, MaxDelay: 5 * time.Minute},
+		Retention: Retention{MaxCount: 10},
+		Limits:    Limits{MaxBodyBytes: 1 << 20},
+		Log:       LogConfig{Format: "text", Level: "info"},
+	}
+}
+
+// bindFlags 把命令行参数绑定到 cfg 的字段上，默认值取 cfg 当前的值
+func bindFlags(fs *flag.FlagSet, cfg *Config) {
+	fs.StringVar(&cfg.Listen, "listen", cfg.Listen, "监听地址")
+	fs.StringVar(&cfg.StaticDir, "static", cfg.StaticDir, "静态文件目录")
+	fs.DurationVar(&cfg.Timeouts.ReadHeader, "read-header-timeout", cfg.Timeouts.ReadHeader, "读取请求头的超时时间")
+	fs.DurationVar(&cfg.Timeouts.Read, "read-timeout", cfg.Timeouts.Read, "读取整个请求的超时时间")
+	fs.DurationVar(&cfg.Timeouts.Write, "write-timeout", cfg.Timeouts.Write, "写响应的超时时间，日志推送连接不受限制")
+	fs.DurationVar(&cfg.Timeouts.Idle, "idle-timeout", cfg.Timeouts.Idle, "keep-alive 连接的空闲超时")
+	fs.DurationVar(&cfg.Timeouts.Shutdown, "shutdown-timeout", cfg.Timeouts.Shutdown, "关闭时等待请求与排队投递完成的时间")
+	fs.StringVar(&cfg.TLS.CertFile, "tls-cert", cfg.TLS.CertFile, "TLS 证书文件，与 -tls-key 同时设置时启用 HTTPS")
+	fs.StringVar(&cfg.TLS.KeyFile, "tls-key", cfg.TLS.KeyFile, "TLS 私钥文件")
+	fs.StringVar(&cfg.TLS.ClientCAFile, "tls-client-ca", cfg.TLS.ClientCAFile, "校验客户端证书的 CA 文件，供要求 mTLS 的 Webhook 使用")
+	fs.DurationVar(&cfg.TLS.ReloadInterval, "tls-reload-interval", cfg.TLS.ReloadInterval, "检查证书文件变化的间隔，0 表示只在收到 SIGHUP 时重新加载")
+	fs.StringVar(&cfg.Store.Kind, "store", cfg.Store.Kind, "存储类型：memory 或 file")
+	fs.StringVar(&cfg.Store.Path, "data", cfg.Store.Path, "file 存储的数据文件路径")
+	fs.IntVar(&cfg.Queue.Workers, "workers", cfg.Queue.Workers, "投递 worker 数量")
+	fs.IntVar(&cfg.Queue.Size, "queue-size", cfg.Queue.Size, "投递队列容量")
+	fs.IntVar(&cfg.Retry.MaxRetries, "retries", cfg.Retry.MaxRetries, "投递失败后的最大重试次数")
+	fs.DurationVar(&cfg.Retry.BaseDelay, "retry-base", cfg.Retry.BaseDelay, "首次重试前的等待时间")
+	fs.DurationVar(&cfg.Retry.MaxDelay, "retry-max", cfg.Retry.MaxDelay, "重试等待时间上限")
+	fs.IntVar(&cfg.Retention.MaxCount, "log-max-count", cfg.Retention.MaxCount, "每个 Webhook 默认保留的日志条数")
+	fs.DurationVar(&cfg.Retention.MaxAge, "log-max-age", cfg.Retention.MaxAge, "日志默认保留时长，0 表示不按时间清理")
+	fs.StringVar(&cfg.Log.Format, "log-format", cfg.Log.Format, "日志格式：text 或 json")
+	fs.StringVar(&cfg.Log.Level, "log-level", cfg.Log.Level, "日志级别：debug、info、warn 或 error")
+	fs.StringVar(&cfg.MetricsToken, "metrics-token", cfg.MetricsToken, "抓取 /metrics 使用的 Bearer Token")
+	fs.Int64Var(&cfg.Limits.MaxBodyBytes, "max-body", cfg.Limits.MaxBodyBytes, "入站请求体默认上限（字节），必须大于 0")
+	fs.Float64Var(&cfg.Limits.Rate, "rate", cfg.Limits.Rate, "每个 Webhook 默认每秒允许的请求数，0 表示不限制")
+	fs.IntVar(&cfg.Limits.Burst, "burst", cfg.Limits.Burst, "每个 Webhook 令牌桶容量，0 表示取每秒请求数")
+	fs.Float64Var(&cfg.Limits.IPRate, "ip-rate", cfg.Limits.IPRate, "同一来源 IP 默认每秒允许的请求数，0 表示不限制")
+	fs.IntVar(&cfg.Limits.IPBurst, "ip-burst", cfg.Limits.IPBurst, "来源 IP 令牌桶容量，0 表示取每秒请求数")
+}
+
+// loadConfig 解析命令行参数，读取配置文件并应用环境变量，最后以显式传入的参数为准
+func loadConfig(args []string) (Config, error) {
+	cfg := defaultConfig()
+	fs := flag.NewFlagSet(args[0], flag.ExitOnError)
+	path := fs.String("config", os.Getenv(envPrefix+"CONFIG"), "配置文件路径（YAML），文件中的 ${VAR} 替换为环境变量")
+	bindFlags(fs, &cfg)
+	fs.Parse(args[1:])
+
+	// 先记下显式传入的参数，加载文件和环境变量后重新设置
+	explicit := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = f.Value.String() })
+
+	cfg = defaultConfig()
+	if *path != "" {
+		if err := cfg.readFile(*path); err != nil {
+			return cfg, err
+		}
+	}
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+		env := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if v, ok := os.LookupEnv(env); ok {
+			if err := fs.Set(f.Name, v); err != nil {
+				errs = append(errs, fmt.Errorf("config: %s=%q: %w", env, v, err))
+			}
+		}
+	})
+	for name, v := range explicit {
+		fs.Set(name, v)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return cfg, err
+	}
+	clientCAConfigured = cfg.TLS.CertFile != "" && cfg.TLS.ClientCAFile != ""
+	return cfg, cfg.Validate()
+}
+
+// envRef 匹配配置文件中的 ${VAR} 与转义写法 $${。其他 $ 原样保留，
+// 如模板变量 $i、正则中的 $ 和含 $ 的密钥
+var envRef = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv 把 ${VAR} 替换为环境变量，未设置时为空；$${ 表示字面的 ${
+func expandEnv(s string) string {
+	return envRef.ReplaceAllStringFunc(s, func(m string) string {
+		if m == "$${" {
+			return "${"
+		}
+		return os.Getenv(m[2 : len(m)-1])
+	})
+}
+
+func (c *Config) readFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	dec := yaml.NewDecoder(strings.NewReader(expandEnv(string(b))))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate 检查全部配置项，一次报告所有错误
+func (c *Config) Validate() error {
+	var errs []error
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("config: "+format, args...))
+	}
+	if c.Listen == "" {
+		add("listen must not be empty")
+	}
+	t := c.Timeouts
+	if t.ReadHeader < 0 || t.Read < 0 || t.Write < 0 || t.Idle < 0 || t.Shutdown < 0 {
+		add("timeouts: values must not be negative")
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		add("tls: cert_file and key_file must be set together")
+	}
+	if c.TLS.ClientCAFile != "" && c.TLS.CertFile == "" {
+		add("tls: client_ca_file requires cert_file and key_file")
+	}
+	if c.TLS.ReloadInterval < 0 {
+		add("tls: reload_interval must not be negative")
+	}
+	switch c.Store.Kind {
+	case "memory":
+	case "file":
+		if c.Store.Path == "" {
+			add("store.path is required for the file store")
+		}
+	default:
+		add("store.kind %q must be memory or file", c.Store.Kind)
+	}
+	if c.Queue.Workers < 1 {
+		add("queue.workers must be at least 1")
+	}
+	if c.Queue.Size < 1 {
+		add("queue.size must be at least 1")
+	}
+	if c.Retry.MaxRetries < 0 || c.Retry.BaseDelay < 0 || c.Retry.MaxDelay < 0 {
+		add("retry: values must not be negative")
+	}
+	if c.Retention.MaxCount < 0 || c.Retention.MaxAge < 0 {
+		add("retention: values must not be negative")
+	}
+	if err := c.Log.Validate(); err != nil {
+		add("log: %v", err)
+	}
+	if err := c.Limits.Validate(); err != nil {
+		add("limits: %v", err)
+	} else if c.Limits.MaxBodyBytes == 0 {
+		// Hook 的 0 表示沿用全局值，全局值为 0 会让所有非空请求返回 413
+		add("limits.max_body_bytes must be greater than 0")
+	}
+	seen := make(map[string]bool)
+	for i, h := range c.Hooks {
+		switch {
+		case h.ID == "":
+			add("hooks[%d]: id is required", i)
+			continue
+		case strings.ContainsAny(h.ID, "/ \t\n"):
+			add("hooks[%d]: invalid id %q", i, h.ID)
+			continue
+		case seen[h.ID]:
+			add("hooks[%d]: duplicate id %q", i, h.ID)
+			continue
+		}
+		seen[h.ID] = true
+		hook, err := h.build(nil)
+		if err == nil {
+			err = hook.validate()
+		}
+		if err != nil {
+			add("hooks[%d] (%s): %v", i, h.ID, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// build 按配置生成 Hook。配置是完整的声明，未写出的字段恢复为默认值；
+// 未指定签名密钥或管理令牌时沿用已有 Hook 的值，避免每次启动都轮换
+func (h configHook) build(existing *Hook) (*Hook, error) {
+	hook := &Hook{ID: h.ID, Owner: h.Owner, CreatedAt: time.Now()}
+	if h.Token != "" {
+		hook.TokenHash = hashToken(h.Token)
+	}
+	if err := h.hookRequest.apply(hook); err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		hook.CreatedAt = existing.CreatedAt
+		if h.Token == "" {
+			hook.TokenHash = existing.TokenHash
+		}
+		if h.Signing != nil && h.Signing.Secret == "" && existing.Signing != nil {
+			s := *existing.Signing
+			hook.Signing = &s
+		}
+	}
+	return hook, nil
+}
+
+// reconcileHooks 启动时把预定义的 Hook 写入存储：不存在时创建，已存在时替换为配置中的内容。
+// 不在配置中的 Hook 不受影响
+func reconcileHooks(hooks []configHook) error {
+	for _, h := range hooks {
+		existing, err := store.GetHook(h.ID)
+		if err != nil && !errors.Is(err, ErrHookNotFound) {
+			return fmt.Errorf("hook %s: %w", h.ID, err)
+		}
+		hook, err := h.build(existing)
+		if err != nil {
+			return fmt.Errorf("hook %s: %w", h.ID, err)
+		}
+		if existing == nil {
+			if err := store.CreateHook(hook); err != nil {
+				return fmt.Errorf("hook %s: %w", h.ID, err)
+			}
+			slog.Info("hook created from config", "hook", h.ID)
+			continue
+		}
+		if err := store.UpdateHook(hook); err != nil {
+			return fmt.Errorf("hook %s: %w", h.ID, err)
+		}
+		slog.Info("hook updated from config", "hook", h.ID)
+	}
+	return nil
+}
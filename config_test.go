@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfigValidateLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  Limits
+		wantErr string
+	}{
+		{"default", defaultConfig().Limits, ""},
+		{"zero max body", Limits{}, "max_body_bytes must be greater than 0"},
+		{"negative", Limits{MaxBodyBytes: 1, Rate: -1}, "must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := defaultConfig()
+			c.Limits = tt.limits
+			err := c.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("Validate() = %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("Validate() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("WEBHOOK_PROXY_TEST", "value")
+	tests := []struct{ in, want string }{
+		{"secret: ${WEBHOOK_PROXY_TEST}", "secret: value"},
+		{"body: '{{ range $i, $c := .Body }}{{ $i }}{{ end }}'", "body: '{{ range $i, $c := .Body }}{{ $i }}{{ end }}'"},
+		{"value: ^refs/heads/main$", "value: ^refs/heads/main$"},
+		{"literal: $${WEBHOOK_PROXY_TEST}", "literal: ${WEBHOOK_PROXY_TEST}"},
+		{"unset: ${WEBHOOK_PROXY_UNSET}", "unset: "},
+		{"bare: $WEBHOOK_PROXY_TEST", "bare: $WEBHOOK_PROXY_TEST"},
+	}
+	for _, tt := range tests {
+		if got := expandEnv(tt.in); got != tt.want {
+			t.Errorf("expandEnv(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
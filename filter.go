@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 过滤条件支持的比较方式
+const (
+	FilterEq     = "eq"
+	FilterNe     = "ne"
+	FilterRegex  = "regex"
+	FilterIn     = "in"
+	FilterGt     = "gt"
+	FilterGte    = "gte"
+	FilterLt     = "lt"
+	FilterLte    = "lte"
+	FilterExists = "exists"
+)
+
+// Filter 转发条件，不满足时事件记为 skipped 而不转发。
+// 组合节点只设置 All、Any、Not 之一；叶子节点比较一个字段：
+//
+//	header.X-GitHub-Event  请求头
+//	query.ref              查询参数
+//	body.alerts[0].labels.severity  请求体 JSON 路径，与模板中的 jsonpath 相同
+//
+// 字段有多个值（如重复的请求头）时任一值满足即可，ne 为 eq 取反
+type Filter struct {
+	All []Filter `json:"all,omitempty"`
+	Any []Filter `json:"any,omitempty"`
+	Not *Filter  `json:"not,omitempty"`
+
+	Field  string   `json:"field,omitempty"`
+	Op     string   `json:"op,omitempty"`
+	Value  string   `json:"value,omitempty"`  // eq、ne、regex 与数值比较的操作数
+	Values []string `json:"values,omitempty"` // in 的候选值
+}
+
+// empty API 中传入 {} 表示清除过滤条件
+func (f *Filter) empty() bool {
+	return f.All == nil && f.Any == nil && f.Not == nil && f.Field == ""
+}
+
+func (f *Filter) Validate() error {
+	nodes := 0
+	for _, set := range []bool{f.All != nil, f.Any != nil, f.Not != nil, f.Field != ""} {
+		if set {
+			nodes++
+		}
+	}
+	if nodes != 1 {
+		return errors.New("exactly one of all, any, not or field must be set")
+	}
+	for i := range f.All {
+		if err := f.All[i].Validate(); err != nil {
+			return fmt.Errorf("all[%d]: %w", i, err)
+		}
+	}
+	for i := range f.Any {
+		if err := f.Any[i].Validate(); err != nil {
+			return fmt.Errorf("any[%d]: %w", i, err)
+		}
+	}
+	if f.Not != nil {
+		if err := f.Not.Validate(); err != nil {
+			return fmt.Errorf("not: %w", err)
+		}
+	}
+	if f.Field == "" {
+		return nil
+	}
+	source, _, _ := strings.Cut(f.Field, ".")
+	if source != "header" && source != "query" && source != "body" {
+		return fmt.Errorf("field %q must be header.<name>, query.<name> or body.<path>", f.Field)
+	}
+	switch f.Op {
+	case FilterEq, FilterNe, FilterExists:
+	case FilterIn:
+		if len(f.Values) == 0 {
+			return fmt.Errorf("%s: values must not be empty", f.Field)
+		}
+	case FilterRegex:
+		if _, err := filterRegexp(f.Value); err != nil {
+			return fmt.Errorf("%s: %w", f.Field, err)
+		}
+	case FilterGt, FilterGte, FilterLt, FilterLte:
+		if _, ok := filterNumber(f.Value); !ok {
+			return fmt.Errorf("%s: value %q is not a number", f.Field, f.Value)
+		}
+	default:
+		return fmt.Errorf("%s: unknown op %q", f.Field, f.Op)
+	}
+	return nil
+}
+
+// maxCachedRegexps 缓存的正则数上限，与 maxCachedTemplates 相同，达到上限时清空缓存，
+// 由仍在使用的条件重新填充
+const maxCachedRegexps = 1024
+
+var (
+	filterRegexpMu sync.Mutex
+	filterRegexps  = make(map[string]*regexp.Regexp) // 编译后的正则，避免每个事件重新编译
+)
+
+func filterRegexp(pattern string) (*regexp.Regexp, error) {
+	filterRegexpMu.Lock()
+	re, ok := filterRegexps[pattern]
+	filterRegexpMu.Unlock()
+	if ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	filterRegexpMu.Lock()
+	if len(filterRegexps) >= maxCachedRegexps {
+		clear(filterRegexps)
+	}
+	filterRegexps[pattern] = re
+	filterRegexpMu.Unlock()
+	return re, nil
+}
+
+// filterInput 惰性解析查询参数与 JSON 请求体，同一事件只解析一次
+type filterInput struct {
+	in     *inboundRequest
+	query  url.Values
+	body   interface{}
+	parsed bool
+}
+
+func (fi *filterInput) values(field string) []string {
+	source, key, _ := strings.Cut(field, ".")
+	switch source {
+	case "header":
+		return fi.in.Header.Values(http.CanonicalHeaderKey(key))
+	case "query":
+		if fi.query == nil {
+			fi.query, _ = url.ParseQuery(fi.in.RawQuery)
+		}
+		return fi.query[key]
+	case "body":
+		if !fi.parsed {
+			fi.parsed = true
+			// 与模板一致保留数字原文，大整数不会因 float64 丢失精度
+			dec := json.NewDecoder(bytes.NewReader(fi.in.Body))
+			dec.UseNumber()
+			if err := dec.Decode(&fi.body); err != nil {
+				fi.body = nil
+			}
+		}
+		v := jsonPath(key, fi.body)
+		if v == nil {
+			return nil
+		}
+		return []string{filterString(v)}
+	}
+	return nil
+}
+
+// filterString 把 JSON 值转为比较用的字符串：数字不带多余的小数位，对象和数组为 JSON 文本
+func filterString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		// 整数保持原样，其余数字去掉多余的小数位，如 1.50 为 1.5
+		if n, ok := filterNumber(v.String()); ok && n.IsInt() {
+			return n.Num().String()
+		}
+		if f, err := v.Float64(); err == nil {
+			return strconv.FormatFloat(f, 'f', -1, 64)
+		}
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// match 判断事件是否满足条件，条件已通过 Validate 检查
+func (f *Filter) match(fi *filterInput) bool {
+	switch {
+	case f.All != nil:
+		for i := range f.All {
+			if !f.All[i].match(fi) {
+				return false
+			}
+		}
+		return true
+	case f.Any != nil:
+		for i := range f.Any {
+			if f.Any[i].match(fi) {
+				return true
+			}
+		}
+		return false
+	case f.Not != nil:
+		return !f.Not.match(fi)
+	}
+
+	values := fi.values(f.Field)
+	switch f.Op {
+	case FilterExists:
+		return len(values) > 0
+	case FilterNe:
+		return !slices.Contains(values, f.Value)
+	}
+	for _, v := range values {
+		if f.compare(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Filter) compare(v string) bool {
+	switch f.Op {
+	case FilterEq:
+		return v == f.Value
+	case FilterIn:
+		return slices.Contains(f.Values, v)
+	case FilterRegex:
+		re, err := filterRegexp(f.Value)
+		return err == nil && re.MatchString(v)
+	}
+	n, ok := filterNumber(v)
+	if !ok {
+		return false
+	}
+	want, _ := filterNumber(f.Value)
+	switch c := n.Cmp(want); f.Op {
+	case FilterGt:
+		return c > 0
+	case FilterGte:
+		return c >= 0
+	case FilterLt:
+		return c < 0
+	case FilterLte:
+		return c <= 0
+	}
+	return false
+}
+
+// decimalNumber 十进制数字，限制指数位数，避免请求中的 1e999999999 之类的值耗尽内存
+var decimalNumber = regexp.MustCompile(`^[+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)([eE][+-]?[0-9]{1,3})?$`)
+
+// filterNumber 把十进制数字解析为精确的有理数，按数值比较时不受 float64 精度影响
+func filterNumber(s string) (*big.Rat, bool) {
+	if !decimalNumber.MatchString(s) {
+		return nil, false
+	}
+	return new(big.Rat).SetString(s)
+}
+
+// passes 没有配置条件时全部转发
+func (f *Filter) passes(in *inboundRequest) bool {
+	return f == nil || f.match(&filterInput{in: in})
+}
+
+// parseFilterForm 表单中的过滤条件为 JSON 文本
+func parseFilterForm(r *http.Request) (*Filter, error) {
+	s := strings.TrimSpace(r.FormValue("filter"))
+	if s == "" {
+		return nil, nil
+	}
+	var f Filter
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&f); err != nil {
+		return nil, err
+	}
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestFilterPasses(t *testing.T) {
+	in := &inboundRequest{
+		Header:   http.Header{"X-Github-Event": {"push"}, "X-Tag": {"a", "b"}},
+		RawQuery: "ref=main&n=10",
+		Body: []byte(`{"id":12345678901234567891,"price":1.50,"count":3,"neg":-2.5,"exp":1e3,` +
+			`"ref":"refs/heads/main","ok":true,"labels":{"severity":"critical"},"alerts":[{"status":"firing"}]}`),
+	}
+	leaf := func(field, op, value string) Filter { return Filter{Field: field, Op: op, Value: value} }
+	tests := []struct {
+		name string
+		f    Filter
+		want bool
+	}{
+		{"header eq", leaf("header.X-GitHub-Event", FilterEq, "push"), true},
+		{"header any value", leaf("header.X-Tag", FilterEq, "b"), true},
+		{"header ne", leaf("header.X-GitHub-Event", FilterNe, "push"), false},
+		{"query eq", leaf("query.ref", FilterEq, "main"), true},
+		{"query numeric", leaf("query.n", FilterGt, "9.5"), true},
+		{"body nested", leaf("body.labels.severity", FilterEq, "critical"), true},
+		{"body array", leaf("body.alerts[0].status", FilterEq, "firing"), true},
+		{"body bool", leaf("body.ok", FilterEq, "true"), true},
+		{"regex", leaf("body.ref", FilterRegex, `^refs/heads/(main|master)$`), true},
+		{"regex no match", leaf("body.ref", FilterRegex, `^refs/tags/`), false},
+		{"in", Filter{Field: "body.labels.severity", Op: FilterIn, Values: []string{"warning", "critical"}}, true},
+		{"exists", leaf("body.labels.severity", FilterExists, ""), true},
+		{"missing", leaf("body.labels.team", FilterExists, ""), false},
+		{"missing ne", leaf("body.labels.team", FilterNe, "ops"), true},
+
+		// 大整数按原文比较，不经过 float64
+		{"big int eq", leaf("body.id", FilterEq, "12345678901234567891"), true},
+		{"big int eq neighbour", leaf("body.id", FilterEq, "12345678901234567890"), false},
+		{"big int gt", leaf("body.id", FilterGt, "12345678901234567890"), true},
+		{"big int lte", leaf("body.id", FilterLte, "12345678901234567890"), false},
+		{"big int gte self", leaf("body.id", FilterGte, "12345678901234567891"), true},
+		{"decimal eq normalized", leaf("body.price", FilterEq, "1.5"), true},
+		{"decimal gte", leaf("body.price", FilterGte, "1.50"), true},
+		{"decimal lt", leaf("body.price", FilterLt, "1.5000001"), true},
+		{"negative", leaf("body.neg", FilterLt, "-2"), true},
+		{"exponent body", leaf("body.exp", FilterEq, "1000"), true},
+		{"exponent value", leaf("body.count", FilterLt, "1e1"), true},
+		{"number against string", leaf("body.ref", FilterGt, "0"), false},
+
+		{"all", Filter{All: []Filter{
+			leaf("header.X-GitHub-Event", FilterEq, "push"),
+			leaf("body.count", FilterGte, "3"),
+		}}, true},
+		{"all one fails", Filter{All: []Filter{
+			leaf("header.X-GitHub-Event", FilterEq, "push"),
+			leaf("body.count", FilterGt, "3"),
+		}}, false},
+		{"any", Filter{Any: []Filter{
+			leaf("body.labels.severity", FilterEq, "warning"),
+			leaf("body.ok", FilterEq, "true"),
+		}}, true},
+		{"any none", Filter{Any: []Filter{
+			leaf("body.labels.severity", FilterEq, "warning"),
+			leaf("body.ok", FilterEq, "false"),
+		}}, false},
+		{"not", Filter{Not: &Filter{Field: "body.ref", Op: FilterRegex, Value: "^refs/tags/"}}, true},
+		{"nested all any not", Filter{All: []Filter{
+			{Any: []Filter{
+				leaf("body.labels.severity", FilterEq, "warning"),
+				{All: []Filter{
+					leaf("body.labels.severity", FilterEq, "critical"),
+					leaf("body.id", FilterGt, "1e19"),
+				}},
+			}},
+			{Not: &Filter{Any: []Filter{
+				leaf("query.ref", FilterEq, "dev"),
+				leaf("body.price", FilterGt, "2"),
+			}}},
+		}}, true},
+		{"nested not fails", Filter{All: []Filter{
+			leaf("body.ok", FilterEq, "true"),
+			{Not: &Filter{All: []Filter{
+				leaf("query.ref", FilterEq, "main"),
+				leaf("body.price", FilterLt, "2"),
+			}}},
+		}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.f.Validate(); err != nil {
+				t.Fatalf("Validate() = %v", err)
+			}
+			if got := tt.f.passes(in); got != tt.want {
+				t.Fatalf("passes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterNonJSONBody(t *testing.T) {
+	in := &inboundRequest{Header: http.Header{}, Body: []byte("payload=1")}
+	f := Filter{Field: "body.payload", Op: FilterExists}
+	if f.passes(in) {
+		t.Fatal("passes() = true for a non-JSON body")
+	}
+}
+
+func TestFilterValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		f       Filter
+		wantErr bool
+	}{
+		{"leaf", Filter{Field: "body.a", Op: FilterEq, Value: "x"}, false},
+		{"empty", Filter{}, true},
+		{"two kinds", Filter{Field: "body.a", Op: FilterEq, All: []Filter{{Field: "body.b", Op: FilterExists}}}, true},
+		{"bad source", Filter{Field: "path.a", Op: FilterEq}, true},
+		{"unknown op", Filter{Field: "body.a", Op: "like"}, true},
+		{"bad regex", Filter{Field: "body.a", Op: FilterRegex, Value: "("}, true},
+		{"empty in", Filter{Field: "body.a", Op: FilterIn}, true},
+		{"not a number", Filter{Field: "body.a", Op: FilterGt, Value: "ten"}, true},
+		{"hex number", Filter{Field: "body.a", Op: FilterGt, Value: "0x10"}, true},
+		{"huge exponent", Filter{Field: "body.a", Op: FilterGt, Value: "1e999999999"}, true},
+		{"nested error", Filter{Any: []Filter{{Field: "body.a", Op: FilterEq}, {Field: "body.b", Op: "bad"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.f.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFilterRegexpCacheBounded(t *testing.T) {
+	for i := 0; i < maxCachedRegexps+10; i++ {
+		if _, err := filterRegexp(fmt.Sprintf("^event-%d$", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	filterRegexpMu.Lock()
+	n := len(filterRegexps)
+	filterRegexpMu.Unlock()
+	if n > maxCachedRegexps {
+		t.Fatalf("cache holds %d patterns, limit %d", n, maxCachedRegexps)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"webhook-proxy/utils"
+)
+
+// ForwardConfig 转发方式配置
+type ForwardConfig struct {
+	// Faithful 为 true 时原样转发请求方法、查询参数、请求头和 Content-Type，
+	// 否则沿用旧行为：统一以 application/json POST 到目标地址
+	Faithful bool `json:"faithful"`
+	// AllowHeaders 非空时只转发列表中的请求头
+	AllowHeaders []string `json:"allow_headers,omitempty"`
+	// DenyHeaders 中的请求头不会被转发，优先级高于 AllowHeaders
+	DenyHeaders []string `json:"deny_headers,omitempty"`
+}
+
+// hopHeaders 逐跳请求头，任何模式下都不转发
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+	"Host",
+	"Content-Length",
+}
+
+var forwardClient = &http.Client{Timeout: 30 * time.Second}
+
+// inboundRequest 入站请求快照，转发时据此重建请求；死信中会持久化保存
+type inboundRequest struct {
+	Method     string      `json:"method"`
+	RawQuery   string      `json:"raw_query,omitempty"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	RemoteAddr string      `json:"remote_addr"`
+	Host       string      `json:"host"`
+	TLS        bool        `json:"tls,omitempty"`
+	RequestID  string      `json:"request_id,omitempty"` // 转发时通过 X-Request-ID 传给目标
+}
+
+func captureInbound(r *http.Request, body []byte) *inboundRequest {
+	return &inboundRequest{
+		Method:     r.Method,
+		RawQuery:   r.URL.RawQuery,
+		Header:     r.Header.Clone(),
+		Body:       body,
+		RemoteAddr: r.RemoteAddr,
+		Host:       r.Host,
+		TLS:        r.TLS != nil,
+		RequestID:  utils.RequestID(r.Context()),
+	}
+}
+
+// newForwardRequest 根据 Hook 配置构造发往 targetURL 的请求，配置了 Transform 时一并改写
+func newForwardRequest(hook *Hook, targetURL string, in *inboundRequest) (*http.Request, error) {
+	req, err := buildForwardRequest(hook, targetURL, in)
+	if err != nil {
+		return nil, err
+	}
+	if hook.Transform != nil {
+		if err := hook.Transform.apply(req, in); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+func buildForwardRequest(hook *Hook, targetURL string, in *inboundRequest) (*http.Request, error) {
+	if !hook.Forward.Faithful {
+		req, err := http.NewRequest(http.MethodPost, targetURL, bytes.NewReader(in.Body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	}
+
+	target, err := url.Parse(targetURL)
+	if err != nil {
+		return nil, err
+	}
+	target.RawQuery = mergeQuery(target.RawQuery, in.RawQuery)
+
+	req, err := http.NewRequest(in.Method, target.String(), bytes.NewReader(in.Body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = filterHeaders(in.Header, hook.Forward)
+	setForwardedHeaders(req.Header, in)
+	return req, nil
+}
+
+// mergeQuery 目标地址自带的参数在前，入站参数追加在后
+func mergeQuery(targetQuery, inboundQuery string) string {
+	switch {
+	case targetQuery == "":
+		return inboundQuery
+	case inboundQuery == "":
+		return targetQuery
+	default:
+		return targetQuery + "&" + inboundQuery
+	}
+}
+
+func filterHeaders(src http.Header, cfg ForwardConfig) http.Header {
+	dst := make(http.Header, len(src))
+	for k, vv := range src {
+		if containsHeader(hopHeaders, k) || containsHeader(cfg.DenyHeaders, k) {
+			continue
+		}
+		if len(cfg.AllowHeaders) > 0 && !containsHeader(cfg.AllowHeaders, k) {
+			continue
+		}
+		dst[k] = append([]string(nil), vv...)
+	}
+	// Connection 中声明的请求头同样属于逐跳头
+	for _, v := range src.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			dst.Del(strings.TrimSpace(name))
+		}
+	}
+	return dst
+}
+
+func containsHeader(list []string, name string) bool {
+	for _, h := range list {
+		if strings.EqualFold(h, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func setForwardedHeaders(h http.Header, in *inboundRequest) {
+	clientIP, _, err := net.SplitHostPort(in.RemoteAddr)
+	if err != nil {
+		clientIP = in.RemoteAddr
+	}
+	if prior := in.Header.Get("X-Forwarded-For"); prior != "" {
+		clientIP = prior + ", " + clientIP
+	}
+	h.Set("X-Forwarded-For", clientIP)
+	h.Set("X-Forwarded-Host", in.Host)
+	if in.TLS {
+		h.Set("X-Forwarded-Proto", "https")
+	} else {
+		h.Set("X-Forwarded-Proto", "http")
+	}
+}
+
+// splitList 解析表单中以逗号或换行分隔的列表
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
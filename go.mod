@@ -1,3 +1,8 @@
 module webhook-proxy
 
 go 1.22.7
+
+require (
+	golang.org/x/crypto v0.31.0
+	gopkg.in/yaml.v3 v3.0.1
+)
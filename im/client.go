@@ -1,5 +1,7 @@
 package im
 
+import "context"
+
 type Department struct {
 	ID   int
 	Name string
@@ -32,11 +34,11 @@ type Message struct {
 	Content interface{}
 }
 
-// Client 统一接口
+// Client 统一接口，ctx 用于取消请求，并携带请求 ID 以关联日志（见 utils.WithRequestID）
 type Client interface {
-	SendMessage(toUserIDs []string, toDeptIDs []string, msg Message) error
-	GetDepartments() ([]Department, error)
-	GetUsers() ([]User, error)
+	SendMessage(ctx context.Context, toUserIDs []string, toDeptIDs []string, msg Message) error
+	GetDepartments(ctx context.Context) ([]Department, error)
+	GetUsers(ctx context.Context) ([]User, error)
 }
 
 // Article 图文消息结构
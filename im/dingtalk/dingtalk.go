@@ -1,21 +1,22 @@
 package dingtalk
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"strings"
+	"sync"
 	"time"
 	"webhook-proxy/im"
+	"webhook-proxy/utils"
 )
 
 type DingTalkClient struct {
 	AppKey    string
 	AppSecret string
+	AgentID   int64 // 发送工作通知使用的应用 ID
 
+	mu       sync.Mutex // 保护 token 缓存
 	token    string
 	tokenExp time.Time
 }
@@ -27,49 +28,70 @@ func NewDingTalkClient(appKey, appSecret string) *DingTalkClient {
 	}
 }
 
-func (d *DingTalkClient) getAccessToken() (string, error) {
+// 值得重试的错误码：系统繁忙、调用被限流
+var retryCodes = map[int]bool{-1: true, 88: true, 90018: true}
+
+// tokenCodes access token 无效或过期，清除缓存后重试会重新获取
+var tokenCodes = map[int]bool{40014: true, 42001: true}
+
+// apiError 构造平台错误，access token 失效时清除缓存，下次调用重新获取
+func (d *DingTalkClient) apiError(code int, msg string) error {
+	if tokenCodes[code] {
+		d.mu.Lock()
+		d.token = ""
+		d.mu.Unlock()
+	}
+	return &im.APIError{Code: code, Msg: msg, Temporary: retryCodes[code] || tokenCodes[code]}
+}
+
+func (d *DingTalkClient) getAccessToken(ctx context.Context) (token string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	if d.token != "" && time.Now().Before(d.tokenExp) {
 		return d.token, nil
 	}
+	defer func() { im.ObserveTokenRefresh(ctx, "dingtalk", err) }()
 	url := fmt.Sprintf("https://oapi.dingtalk.com/gettoken?appkey=%s&appsecret=%s", d.AppKey, d.AppSecret)
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-	body, _ := ioutil.ReadAll(resp.Body)
 	var res struct {
 		ErrCode     int    `json:"errcode"`
 		ErrMsg      string `json:"errmsg"`
 		AccessToken string `json:"access_token"`
 		ExpiresIn   int    `json:"expires_in"`
 	}
-	if err := json.Unmarshal(body, &res); err != nil {
+	if err := utils.HttpGetJSON(ctx, url, &res, nil); err != nil {
 		return "", err
 	}
 	if res.ErrCode != 0 {
-		return "", errors.New(res.ErrMsg)
+		return "", &im.APIError{Code: res.ErrCode, Msg: res.ErrMsg, Temporary: retryCodes[res.ErrCode]}
 	}
 	d.token = res.AccessToken
 	d.tokenExp = time.Now().Add(time.Duration(res.ExpiresIn-60) * time.Second)
 	return d.token, nil
 }
 
-func (d *DingTalkClient) SendMessage(toUserIDs []string, toDeptIDs []string, msg im.Message) error {
-	token, err := d.getAccessToken()
+func (d *DingTalkClient) SendMessage(ctx context.Context, toUserIDs []string, toDeptIDs []string, msg im.Message) (err error) {
+	defer func(start time.Time) { im.ObserveSend(ctx, "dingtalk", msg.Type, start, err) }(time.Now())
+	if d.AgentID == 0 {
+		return errors.New("agent id is required")
+	}
+	token, err := d.getAccessToken(ctx)
 	if err != nil {
 		return err
 	}
-	if len(toUserIDs) == 0 {
-		return errors.New("toUserIDs cannot be empty")
+	if len(toUserIDs) == 0 && len(toDeptIDs) == 0 {
+		return errors.New("toUserIDs and toDeptIDs cannot both be empty")
 	}
-	// 钉钉支持多个userid逗号分隔
-	toUserIDStr := strings.Join(toUserIDs, ",")
 
 	body := map[string]interface{}{
-		"agent_id":    123456789, // 这里需要你自己传入或设置
-		"userid_list": toUserIDStr,
-		"msg":         nil,
+		"agent_id": d.AgentID,
+		"msg":      nil,
+	}
+	// 钉钉支持多个 userid、部门 ID 逗号分隔
+	if len(toUserIDs) > 0 {
+		body["userid_list"] = strings.Join(toUserIDs, ",")
+	}
+	if len(toDeptIDs) > 0 {
+		body["dept_id_list"] = strings.Join(toDeptIDs, ",")
 	}
 	msgPayload, err := d.convertMessage(msg)
 	if err != nil {
@@ -78,41 +100,26 @@ func (d *DingTalkClient) SendMessage(toUserIDs []string, toDeptIDs []string, msg
 	body["msg"] = msgPayload
 
 	url := fmt.Sprintf("https://oapi.dingtalk.com/topapi/message/corpconversation/asyncsend_v2?access_token=%s", token)
-	b, _ := json.Marshal(body)
-
-	resp, err := http.Post(url, "application/json", bytes.NewReader(b))
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	respBody, _ := ioutil.ReadAll(resp.Body)
 	var res struct {
 		ErrCode int    `json:"errcode"`
 		ErrMsg  string `json:"errmsg"`
 	}
-	if err := json.Unmarshal(respBody, &res); err != nil {
+	if err := utils.HttpPostJSON(ctx, url, body, &res, nil); err != nil {
 		return err
 	}
 	if res.ErrCode != 0 {
-		return errors.New(res.ErrMsg)
+		return d.apiError(res.ErrCode, res.ErrMsg)
 	}
 	return nil
 }
 
-func (d *DingTalkClient) GetDepartments() ([]im.Department, error) {
-	token, err := d.getAccessToken()
+func (d *DingTalkClient) GetDepartments(ctx context.Context) ([]im.Department, error) {
+	token, err := d.getAccessToken(ctx)
 	if err != nil {
 		return nil, err
 	}
 	url := fmt.Sprintf("https://oapi.dingtalk.com/department/list?access_token=%s", token)
 
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	body, _ := ioutil.ReadAll(resp.Body)
-
 	var res struct {
 		ErrCode    int    `json:"errcode"`
 		ErrMsg     string `json:"errmsg"`
@@ -122,11 +129,11 @@ func (d *DingTalkClient) GetDepartments() ([]im.Department, error) {
 			ParentID int    `json:"parentid"`
 		} `json:"department"`
 	}
-	if err := json.Unmarshal(body, &res); err != nil {
+	if err := utils.HttpGetJSON(ctx, url, &res, nil); err != nil {
 		return nil, err
 	}
 	if res.ErrCode != 0 {
-		return nil, errors.New(res.ErrMsg)
+		return nil, d.apiError(res.ErrCode, res.ErrMsg)
 	}
 	var depts []im.Department
 	for _, dd := range res.Department {
@@ -138,21 +145,14 @@ func (d *DingTalkClient) GetDepartments() ([]im.Department, error) {
 	return depts, nil
 }
 
-func (d *DingTalkClient) GetUsers() ([]im.User, error) {
-	token, err := d.getAccessToken()
+func (d *DingTalkClient) GetUsers(ctx context.Context) ([]im.User, error) {
+	token, err := d.getAccessToken(ctx)
 	if err != nil {
 		return nil, err
 	}
 	// 钉钉获取部门用户列表接口，默认取根部门ID=1
 	url := fmt.Sprintf("https://oapi.dingtalk.com/user/listbypage?access_token=%s&department_id=1&offset=0&size=100", token)
 
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	body, _ := ioutil.ReadAll(resp.Body)
-
 	var res struct {
 		ErrCode  int    `json:"errcode"`
 		ErrMsg   string `json:"errmsg"`
@@ -162,11 +162,11 @@ func (d *DingTalkClient) GetUsers() ([]im.User, error) {
 			DeptIDList []int  `json:"department"`
 		} `json:"userlist"`
 	}
-	if err := json.Unmarshal(body, &res); err != nil {
+	if err := utils.HttpGetJSON(ctx, url, &res, nil); err != nil {
 		return nil, err
 	}
 	if res.ErrCode != 0 {
-		return nil, errors.New(res.ErrMsg)
+		return nil, d.apiError(res.ErrCode, res.ErrMsg)
 	}
 
 	var users []im.User
@@ -209,8 +209,65 @@ func (d *DingTalkClient) convertMessage(msg im.Message) (map[string]interface{},
 				"media_id": img.MediaID,
 			},
 		}, nil
+	case im.MarkdownMsg:
+		md, ok := msg.Content.(im.MarkdownContent)
+		if !ok {
+			return nil, errors.New("invalid content for markdown message")
+		}
+		return map[string]interface{}{
+			"msgtype": "markdown",
+			"markdown": map[string]string{
+				"title": markdownTitle(md.Content),
+				"text":  md.Content,
+			},
+		}, nil
+	case im.TextCardMsg:
+		card, ok := msg.Content.(im.TextCardContent)
+		if !ok {
+			return nil, errors.New("invalid content for textcard message")
+		}
+		// 钉钉没有文本卡片，使用整体跳转的 ActionCard
+		btn := card.ButtonText
+		if btn == "" {
+			btn = "详情"
+		}
+		return map[string]interface{}{
+			"msgtype": "action_card",
+			"action_card": map[string]string{
+				"title":        card.Title,
+				"markdown":     "### " + card.Title + "\n" + card.Description,
+				"single_title": btn,
+				"single_url":   card.URL,
+			},
+		}, nil
+	case im.NewsMsg:
+		news, ok := msg.Content.(im.NewsContent)
+		if !ok || len(news.Articles) == 0 {
+			return nil, errors.New("invalid content for news message")
+		}
+		// 钉钉链接消息只支持单条图文，取第一篇
+		art := news.Articles[0]
+		return map[string]interface{}{
+			"msgtype": "link",
+			"link": map[string]string{
+				"title":      art.Title,
+				"text":       art.Description,
+				"messageUrl": art.URL,
+				"picUrl":     art.PicURL,
+			},
+		}, nil
 	// 其他消息类型可以继续拓展
 	default:
 		return nil, errors.New("unsupported message type for DingTalk")
 	}
 }
+
+// markdownTitle 钉钉 markdown 消息必须带标题，取正文第一行
+func markdownTitle(content string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(content), "\n")
+	line = strings.TrimSpace(strings.TrimLeft(line, "#> "))
+	if line == "" {
+		return "通知"
+	}
+	return line
+}
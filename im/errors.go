@@ -0,0 +1,41 @@
+package im
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// APIError 开放平台接口返回的业务错误，Code 为平台的错误码（errcode / code）
+type APIError struct {
+	Code int
+	Msg  string
+	// Temporary 为 true 表示系统繁忙、调用频率超限或 access token 失效，稍后重试可能成功
+	Temporary bool
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Msg, e.Code)
+}
+
+// ErrorCode 返回错误链中的平台错误码，不是平台业务错误时 ok 为 false
+func ErrorCode(err error) (code int, ok bool) {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Code, true
+	}
+	return 0, false
+}
+
+// Temporary 判断调用失败是否值得重试：网络错误、非 JSON 响应（如网关错误页）和临时的平台错误
+// 返回 true；无效用户、应用被停用、消息内容错误等返回 false，重试也不会成功
+func Temporary(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary
+	}
+	var netErr net.Error
+	var syntaxErr *json.SyntaxError
+	return errors.As(err, &netErr) || errors.As(err, &syntaxErr)
+}
@@ -1,20 +1,20 @@
 package feishu
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
-	"io"
-	"net/http"
 	"strconv"
+	"sync"
 	"time"
 	"webhook-proxy/im"
+	"webhook-proxy/utils"
 )
 
 type FeishuClient struct {
 	AppID     string
 	AppSecret string
 
+	mu       sync.Mutex // 保护 token 缓存
 	token    string
 	tokenExp time.Time
 }
@@ -26,109 +26,106 @@ func NewFeishuClient(appID, appSecret string) *FeishuClient {
 	}
 }
 
-func (f *FeishuClient) getAccessToken() (string, error) {
+// 值得重试的错误码：调用频率超限
+var retryCodes = map[int]bool{99991400: true}
+
+// tokenCodes tenant access token 缺失或无效，清除缓存后重试会重新获取
+var tokenCodes = map[int]bool{99991661: true, 99991663: true, 99991668: true}
+
+// apiError 构造平台错误，access token 失效时清除缓存，下次调用重新获取
+func (f *FeishuClient) apiError(code int, msg string) error {
+	if tokenCodes[code] {
+		f.mu.Lock()
+		f.token = ""
+		f.mu.Unlock()
+	}
+	return &im.APIError{Code: code, Msg: msg, Temporary: retryCodes[code] || tokenCodes[code]}
+}
+
+func (f *FeishuClient) getAccessToken(ctx context.Context) (token string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	if f.token != "" && time.Now().Before(f.tokenExp) {
 		return f.token, nil
 	}
+	defer func() { im.ObserveTokenRefresh(ctx, "feishu", err) }()
 
 	url := "https://open.feishu.cn/open-apis/auth/v3/tenant_access_token/internal/"
 	payload := map[string]string{
 		"app_id":     f.AppID,
 		"app_secret": f.AppSecret,
 	}
-	b, _ := json.Marshal(payload)
-	resp, err := http.Post(url, "application/json", bytes.NewReader(b))
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-	body, _ := io.ReadAll(resp.Body)
 	var res struct {
 		Code              int    `json:"code"`
 		Msg               string `json:"msg"`
 		TenantAccessToken string `json:"tenant_access_token"`
 		Expire            int    `json:"expire"`
 	}
-	if err := json.Unmarshal(body, &res); err != nil {
+	if err := utils.HttpPostJSON(ctx, url, payload, &res, nil); err != nil {
 		return "", err
 	}
 	if res.Code != 0 {
-		return "", errors.New(res.Msg)
+		return "", &im.APIError{Code: res.Code, Msg: res.Msg, Temporary: retryCodes[res.Code]}
 	}
 	f.token = res.TenantAccessToken
 	f.tokenExp = time.Now().Add(time.Duration(res.Expire-60) * time.Second)
 	return f.token, nil
 }
 
-func (f *FeishuClient) SendMessage(toUserIDs []string, toDeptIDs []string, msg im.Message) error {
-	// 飞书暂不支持直接按部门发消息，暂忽略toDeptIDs
-	token, err := f.getAccessToken()
+func (f *FeishuClient) SendMessage(ctx context.Context, toUserIDs []string, toDeptIDs []string, msg im.Message) (err error) {
+	defer func(start time.Time) { im.ObserveSend(ctx, "feishu", msg.Type, start, err) }(time.Now())
+	if len(toUserIDs) == 0 && len(toDeptIDs) == 0 {
+		return errors.New("toUserIDs and toDeptIDs cannot both be empty")
+	}
+	token, err := f.getAccessToken(ctx)
 	if err != nil {
 		return err
 	}
-	var userID string
-	if len(toUserIDs) == 0 {
-		return errors.New("toUserIDs cannot be empty")
-	}
-	// 飞书要求单用户发送
-	userID = toUserIDs[0]
 
-	body := map[string]interface{}{
-		"user_id":  userID,
-		"msg_type": string(msg.Type),
-	}
-	content, err := f.convertMessage(msg)
+	msgType, fields, err := f.convertMessage(msg)
 	if err != nil {
 		return err
 	}
-	body["content"] = content
-
+	body := map[string]interface{}{"msg_type": msgType}
+	// 单个用户使用发送消息接口，多个用户或包含部门时使用批量发送接口
 	url := "https://open.feishu.cn/open-apis/message/v4/send/"
-	reqBody, _ := json.Marshal(body)
-	req, _ := http.NewRequest("POST", url, bytes.NewReader(reqBody))
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", "application/json; charset=utf-8")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
+	if len(toUserIDs) == 1 && len(toDeptIDs) == 0 {
+		body["user_id"] = toUserIDs[0]
+	} else {
+		url = "https://open.feishu.cn/open-apis/message/v4/batch_send/"
+		if len(toUserIDs) > 0 {
+			body["user_ids"] = toUserIDs
+		}
+		if len(toDeptIDs) > 0 {
+			body["department_ids"] = toDeptIDs
+		}
+	}
+	for k, v := range fields {
+		body[k] = v
 	}
-	defer resp.Body.Close()
-	respBody, _ := io.ReadAll(resp.Body)
+
 	var res struct {
 		Code int         `json:"code"`
 		Msg  string      `json:"msg"`
 		Data interface{} `json:"data"`
 	}
-	if err := json.Unmarshal(respBody, &res); err != nil {
+	if err := utils.HttpPostJSON(ctx, url, body, &res, map[string]string{"Authorization": "Bearer " + token}); err != nil {
 		return err
 	}
 	if res.Code != 0 {
-		return errors.New(res.Msg)
+		return f.apiError(res.Code, res.Msg)
 	}
 	return nil
 }
 
-func (f *FeishuClient) GetDepartments() ([]im.Department, error) {
-	token, err := f.getAccessToken()
+func (f *FeishuClient) GetDepartments(ctx context.Context) ([]im.Department, error) {
+	token, err := f.getAccessToken(ctx)
 	if err != nil {
 		return nil, err
 	}
 	// 飞书获取部门列表接口，分页简化，获取全部
 	url := "https://open.feishu.cn/open-apis/contact/v3/departments"
 
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Set("Authorization", "Bearer "+token)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
 	var res struct {
 		Code int    `json:"code"`
 		Msg  string `json:"msg"`
@@ -139,11 +136,11 @@ func (f *FeishuClient) GetDepartments() ([]im.Department, error) {
 			} `json:"items"`
 		} `json:"data"`
 	}
-	if err := json.Unmarshal(body, &res); err != nil {
+	if err := utils.HttpGetJSON(ctx, url, &res, map[string]string{"Authorization": "Bearer " + token}); err != nil {
 		return nil, err
 	}
 	if res.Code != 0 {
-		return nil, errors.New(res.Msg)
+		return nil, f.apiError(res.Code, res.Msg)
 	}
 	var depts []im.Department
 	for _, d := range res.Data.Items {
@@ -156,25 +153,14 @@ func (f *FeishuClient) GetDepartments() ([]im.Department, error) {
 	return depts, nil
 }
 
-func (f *FeishuClient) GetUsers() ([]im.User, error) {
-	token, err := f.getAccessToken()
+func (f *FeishuClient) GetUsers(ctx context.Context) ([]im.User, error) {
+	token, err := f.getAccessToken(ctx)
 	if err != nil {
 		return nil, err
 	}
 	// 飞书获取用户接口，分页简化，获取全部
 	url := "https://open.feishu.cn/open-apis/contact/v3/users?page_size=100"
 
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Set("Authorization", "Bearer "+token)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
 	var res struct {
 		Code int    `json:"code"`
 		Msg  string `json:"msg"`
@@ -186,11 +172,11 @@ func (f *FeishuClient) GetUsers() ([]im.User, error) {
 			} `json:"items"`
 		} `json:"data"`
 	}
-	if err := json.Unmarshal(body, &res); err != nil {
+	if err := utils.HttpGetJSON(ctx, url, &res, map[string]string{"Authorization": "Bearer " + token}); err != nil {
 		return nil, err
 	}
 	if res.Code != 0 {
-		return nil, errors.New(res.Msg)
+		return nil, f.apiError(res.Code, res.Msg)
 	}
 	var users []im.User
 	for _, u := range res.Data.Items {
@@ -203,26 +189,98 @@ func (f *FeishuClient) GetUsers() ([]im.User, error) {
 	return users, nil
 }
 
-func (f *FeishuClient) convertMessage(msg im.Message) (map[string]interface{}, error) {
+// convertMessage 返回飞书消息类型以及需要合并到请求体中的字段
+func (f *FeishuClient) convertMessage(msg im.Message) (string, map[string]interface{}, error) {
 	switch msg.Type {
 	case im.TextMsg:
 		text, ok := msg.Content.(string)
 		if !ok {
-			return nil, errors.New("invalid content for text message")
+			return "", nil, errors.New("invalid content for text message")
 		}
-		return map[string]interface{}{
-			"text": text,
+		return "text", map[string]interface{}{
+			"content": map[string]interface{}{"text": text},
 		}, nil
 	case im.ImageMsg:
 		img, ok := msg.Content.(im.ImageContent)
 		if !ok {
-			return nil, errors.New("invalid content for image message")
+			return "", nil, errors.New("invalid content for image message")
+		}
+		return "image", map[string]interface{}{
+			"content": map[string]interface{}{"image_key": img.MediaID},
+		}, nil
+	case im.MarkdownMsg:
+		md, ok := msg.Content.(im.MarkdownContent)
+		if !ok {
+			return "", nil, errors.New("invalid content for markdown message")
+		}
+		// 飞书通过消息卡片的 markdown 元素展示 markdown
+		return "interactive", map[string]interface{}{
+			"card": map[string]interface{}{
+				"elements": []map[string]interface{}{
+					{"tag": "markdown", "content": md.Content},
+				},
+			},
+		}, nil
+	case im.TextCardMsg:
+		card, ok := msg.Content.(im.TextCardContent)
+		if !ok {
+			return "", nil, errors.New("invalid content for textcard message")
+		}
+		btn := card.ButtonText
+		if btn == "" {
+			btn = "详情"
+		}
+		elements := []map[string]interface{}{
+			{"tag": "markdown", "content": card.Description},
+		}
+		if card.URL != "" {
+			elements = append(elements, map[string]interface{}{
+				"tag": "action",
+				"actions": []map[string]interface{}{{
+					"tag":  "button",
+					"text": map[string]string{"tag": "plain_text", "content": btn},
+					"url":  card.URL,
+					"type": "primary",
+				}},
+			})
+		}
+		return "interactive", map[string]interface{}{
+			"card": map[string]interface{}{
+				"header": map[string]interface{}{
+					"title": map[string]string{"tag": "plain_text", "content": card.Title},
+				},
+				"elements": elements,
+			},
+		}, nil
+	case im.NewsMsg:
+		news, ok := msg.Content.(im.NewsContent)
+		if !ok || len(news.Articles) == 0 {
+			return "", nil, errors.New("invalid content for news message")
+		}
+		// 飞书没有图文消息，使用富文本逐条列出
+		var lines [][]map[string]string
+		for _, art := range news.Articles {
+			lines = append(lines, []map[string]string{
+				{"tag": "a", "text": art.Title, "href": art.URL},
+			})
+			if art.Description != "" {
+				lines = append(lines, []map[string]string{
+					{"tag": "text", "text": art.Description},
+				})
+			}
 		}
-		return map[string]interface{}{
-			"image_key": img.MediaID,
+		return "post", map[string]interface{}{
+			"content": map[string]interface{}{
+				"post": map[string]interface{}{
+					"zh_cn": map[string]interface{}{
+						"title":   news.Articles[0].Title,
+						"content": lines,
+					},
+				},
+			},
 		}, nil
 	// 其他类型可继续扩展
 	default:
-		return nil, errors.New("unsupported message type for Feishu")
+		return "", nil, errors.New("unsupported message type for Feishu")
 	}
 }
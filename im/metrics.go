@@ -0,0 +1,49 @@
+package im
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Metrics 接收客户端调用的统计事件，err 为空表示成功
+type Metrics interface {
+	MessageSent(provider string, msgType MessageType, err error)
+	TokenRefreshed(provider string, err error)
+}
+
+type noMetrics struct{}
+
+func (noMetrics) MessageSent(string, MessageType, error) {}
+func (noMetrics) TokenRefreshed(string, error)           {}
+
+var metrics Metrics = noMetrics{}
+
+// SetMetrics 设置统计接收方，需在创建客户端之前调用
+func SetMetrics(m Metrics) {
+	metrics = m
+}
+
+// ObserveSend 由各平台客户端在 SendMessage 结束时调用，同时记录日志
+func ObserveSend(ctx context.Context, provider string, msgType MessageType, start time.Time, err error) {
+	metrics.MessageSent(provider, msgType, err)
+	attrs := []any{"provider", provider, "msg_type", msgType, "duration", time.Since(start)}
+	if err != nil {
+		if code, ok := ErrorCode(err); ok {
+			attrs = append(attrs, "errcode", code)
+		}
+		slog.WarnContext(ctx, "im: send message failed", append(attrs, "error", err)...)
+		return
+	}
+	slog.InfoContext(ctx, "im: message sent", attrs...)
+}
+
+// ObserveTokenRefresh 由各平台客户端在重新获取 access token 后调用，同时记录日志
+func ObserveTokenRefresh(ctx context.Context, provider string, err error) {
+	metrics.TokenRefreshed(provider, err)
+	if err != nil {
+		slog.WarnContext(ctx, "im: refresh access token failed", "provider", provider, "error", err)
+		return
+	}
+	slog.DebugContext(ctx, "im: access token refreshed", "provider", provider)
+}
@@ -1,9 +1,11 @@
 package wecom
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strconv"
+	"sync"
 	"time"
 	"webhook-proxy/im"
 	"webhook-proxy/utils"
@@ -13,7 +15,9 @@ import (
 type WeComClient struct {
 	CorpID     string
 	CorpSecret string
+	AgentID    int64 // 发送应用消息时使用的应用 ID
 
+	mu       sync.Mutex // 保护 token 缓存
 	token    string
 	tokenExp time.Time
 }
@@ -26,10 +30,29 @@ func NewWeComClient(corpID, corpSecret string) *WeComClient {
 	}
 }
 
-func (w *WeComClient) getAccessToken() (string, error) {
+// 值得重试的错误码：系统繁忙、接口调用频率或并发超限
+var retryCodes = map[int]bool{-1: true, 45009: true, 45033: true}
+
+// tokenCodes access token 缺失、无效或过期，清除缓存后重试会重新获取
+var tokenCodes = map[int]bool{40014: true, 41001: true, 42001: true}
+
+// apiError 构造平台错误，access token 失效时清除缓存，下次调用重新获取
+func (w *WeComClient) apiError(code int, msg string) error {
+	if tokenCodes[code] {
+		w.mu.Lock()
+		w.token = ""
+		w.mu.Unlock()
+	}
+	return &im.APIError{Code: code, Msg: msg, Temporary: retryCodes[code] || tokenCodes[code]}
+}
+
+func (w *WeComClient) getAccessToken(ctx context.Context) (token string, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	if w.token != "" && time.Now().Before(w.tokenExp) {
 		return w.token, nil
 	}
+	defer func() { im.ObserveTokenRefresh(ctx, "wecom", err) }()
 	// 请求获取access_token，示例简单写法，真实项目建议加重试和错误处理
 	url := fmt.Sprintf("https://qyapi.weixin.qq.com/cgi-bin/gettoken?corpid=%s&corpsecret=%s", w.CorpID, w.CorpSecret)
 	var res struct {
@@ -38,12 +61,12 @@ func (w *WeComClient) getAccessToken() (string, error) {
 		AccessToken string `json:"access_token"`
 		ExpiresIn   int    `json:"expires_in"`
 	}
-	err := utils.HttpGetJSON(url, &res)
+	err = utils.HttpGetJSON(ctx, url, &res, nil)
 	if err != nil {
 		return "", err
 	}
 	if res.ErrCode != 0 {
-		return "", errors.New(res.ErrMsg)
+		return "", &im.APIError{Code: res.ErrCode, Msg: res.ErrMsg, Temporary: retryCodes[res.ErrCode]}
 	}
 	w.token = res.AccessToken
 	w.tokenExp = time.Now().Add(time.Duration(res.ExpiresIn-60) * time.Second)
@@ -51,8 +74,12 @@ func (w *WeComClient) getAccessToken() (string, error) {
 }
 
 // 发送消息实现
-func (w *WeComClient) SendMessage(toUserIDs []string, toDeptIDs []string, msg im.Message) error {
-	token, err := w.getAccessToken()
+func (w *WeComClient) SendMessage(ctx context.Context, toUserIDs []string, toDeptIDs []string, msg im.Message) (err error) {
+	defer func(start time.Time) { im.ObserveSend(ctx, "wecom", msg.Type, start, err) }(time.Now())
+	if w.AgentID == 0 {
+		return errors.New("agent id is required")
+	}
+	token, err := w.getAccessToken(ctx)
 	if err != nil {
 		return err
 	}
@@ -66,6 +93,7 @@ func (w *WeComClient) SendMessage(toUserIDs []string, toDeptIDs []string, msg im
 		"touser":  utils.JoinIDs(toUserIDs),
 		"toparty": utils.JoinIDs(toDeptIDs),
 		"msgtype": string(msg.Type),
+		"agentid": w.AgentID,
 	}
 	for k, v := range payload {
 		body[k] = v
@@ -76,19 +104,19 @@ func (w *WeComClient) SendMessage(toUserIDs []string, toDeptIDs []string, msg im
 		ErrCode int    `json:"errcode"`
 		ErrMsg  string `json:"errmsg"`
 	}
-	err = utils.HttpPostJSON(url, body, &res, make(map[string]string))
+	err = utils.HttpPostJSON(ctx, url, body, &res, nil)
 	if err != nil {
 		return err
 	}
 	if res.ErrCode != 0 {
-		return errors.New(res.ErrMsg)
+		return w.apiError(res.ErrCode, res.ErrMsg)
 	}
 	return nil
 }
 
 // 获取所有部门
-func (w *WeComClient) GetDepartments() ([]im.Department, error) {
-	token, err := w.getAccessToken()
+func (w *WeComClient) GetDepartments(ctx context.Context) ([]im.Department, error) {
+	token, err := w.getAccessToken(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -102,12 +130,12 @@ func (w *WeComClient) GetDepartments() ([]im.Department, error) {
 			ParentID int    `json:"parentid"`
 		} `json:"department"`
 	}
-	err = utils.HttpGetJSON(url, &res)
+	err = utils.HttpGetJSON(ctx, url, &res, nil)
 	if err != nil {
 		return nil, err
 	}
 	if res.ErrCode != 0 {
-		return nil, errors.New(res.ErrMsg)
+		return nil, w.apiError(res.ErrCode, res.ErrMsg)
 	}
 
 	var depts []im.Department
@@ -123,8 +151,8 @@ func (w *WeComClient) GetDepartments() ([]im.Department, error) {
 }
 
 // 获取所有用户，递归或分页这里简化，只取根部门下全部用户
-func (w *WeComClient) GetUsers() ([]im.User, error) {
-	token, err := w.getAccessToken()
+func (w *WeComClient) GetUsers(ctx context.Context) ([]im.User, error) {
+	token, err := w.getAccessToken(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -138,12 +166,12 @@ func (w *WeComClient) GetUsers() ([]im.User, error) {
 			Department []int  `json:"department"`
 		} `json:"userlist"`
 	}
-	err = utils.HttpGetJSON(url, &res)
+	err = utils.HttpGetJSON(ctx, url, &res, nil)
 	if err != nil {
 		return nil, err
 	}
 	if res.ErrCode != 0 {
-		return nil, errors.New(res.ErrMsg)
+		return nil, w.apiError(res.ErrCode, res.ErrMsg)
 	}
 	var users []im.User
 	for _, u := range res.UserList {
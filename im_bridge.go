@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"webhook-proxy/im"
+	"webhook-proxy/im/dingtalk"
+	"webhook-proxy/im/feishu"
+	"webhook-proxy/im/wecom"
+)
+
+// IMTarget 将事件转换为 IM 消息，通过 im.Client 发送给指定的用户和部门
+type IMTarget struct {
+	Provider  string         `json:"provider"` // wecom、feishu、dingtalk
+	AppID     string         `json:"app_id"`   // 企业微信 CorpID / 飞书 AppID / 钉钉 AppKey
+	AppSecret string         `json:"app_secret"`
+	AgentID   int64          `json:"agent_id,omitempty"` // 企业微信、钉钉的应用 ID
+	ToUsers   []string       `json:"to_users,omitempty"`
+	ToDepts   []string       `json:"to_depts,omitempty"`
+	MsgType   im.MessageType `json:"msg_type"`
+	// Template 与 Transform 使用相同的模板函数。text、markdown 消息的渲染结果即为消息内容；
+	// textcard 渲染为 {"title","description","url","btntxt"}，
+	// news 渲染为 {"articles":[{"title","description","url","picurl"}]}
+	Template string `json:"template,omitempty"`
+	// Adapter 非空时使用内置适配器（见 eventAdapters）渲染消息，忽略 Template
+	Adapter string `json:"adapter,omitempty"`
+	// Events 只转发这些事件类型，为空时转发适配器支持的全部事件
+	Events []string `json:"events,omitempty"`
+}
+
+var imProviders = []string{"wecom", "feishu", "dingtalk"}
+
+// targetURL 在投递记录中代表 IM 目标
+func (t *IMTarget) targetURL() string {
+	return "im://" + t.Provider
+}
+
+// Validate 检查配置是否完整、模板能否解析
+func (t *IMTarget) Validate() error {
+	if !slices.Contains(imProviders, t.Provider) {
+		return fmt.Errorf("unknown im provider %q", t.Provider)
+	}
+	if t.AppID == "" || t.AppSecret == "" {
+		return errors.New("im app id and secret are required")
+	}
+	if (t.Provider == "wecom" || t.Provider == "dingtalk") && t.AgentID <= 0 {
+		return fmt.Errorf("im agent id is required for %s", t.Provider)
+	}
+	if len(t.ToUsers) == 0 && len(t.ToDepts) == 0 {
+		return errors.New("im recipients are required")
+	}
+	if t.Adapter != "" {
+		if _, ok := eventAdapters[t.Adapter]; !ok {
+			return fmt.Errorf("unknown adapter %q, available: %s", t.Adapter, strings.Join(adapterNames(), ", "))
+		}
+		switch t.MsgType {
+		case im.TextMsg, im.MarkdownMsg, im.TextCardMsg:
+		default:
+			return fmt.Errorf("adapter does not support %q messages", t.MsgType)
+		}
+		return nil
+	}
+	switch t.MsgType {
+	case im.TextMsg, im.MarkdownMsg, im.TextCardMsg, im.NewsMsg:
+	default:
+		return fmt.Errorf("unsupported im message type %q", t.MsgType)
+	}
+	if _, err := parseTemplate(t.Template); err != nil {
+		return fmt.Errorf("im template: %w", err)
+	}
+	return nil
+}
+
+// cachedIMClient 缓存的客户端及创建它的凭据
+type cachedIMClient struct {
+	key    string
+	client im.Client
+}
+
+var (
+	imClientsMu sync.Mutex
+	imClients   = make(map[string]cachedIMClient) // 按 Hook ID 复用客户端，以便复用 access token
+)
+
+// imClientFor 返回 Hook 的 IM 客户端，凭据变化后替换为新客户端
+func imClientFor(hookID string, t *IMTarget) im.Client {
+	key := strings.Join([]string{t.Provider, t.AppID, t.AppSecret, strconv.FormatInt(t.AgentID, 10)}, "\x00")
+	imClientsMu.Lock()
+	defer imClientsMu.Unlock()
+	if c, ok := imClients[hookID]; ok && c.key == key {
+		return c.client
+	}
+	var c im.Client
+	switch t.Provider {
+	case "wecom":
+		wc := wecom.NewWeComClient(t.AppID, t.AppSecret)
+		wc.AgentID = t.AgentID
+		c = wc
+	case "feishu":
+		c = feishu.NewFeishuClient(t.AppID, t.AppSecret)
+	case "dingtalk":
+		dc := dingtalk.NewDingTalkClient(t.AppID, t.AppSecret)
+		dc.AgentID = t.AgentID
+		c = dc
+	}
+	imClients[hookID] = cachedIMClient{key: key, client: c}
+	return c
+}
+
+// forgetIMClient Hook 删除后释放其客户端
+func forgetIMClient(hookID string) {
+	imClientsMu.Lock()
+	delete(imClients, hookID)
+	imClientsMu.Unlock()
+}
+
+// accepts 判断事件是否需要发送，不需要时返回原因。仅对配置了适配器的目标生效
+func (t *IMTarget) accepts(in *inboundRequest) (bool, string) {
+	if t.Adapter == "" {
+		return true, ""
+	}
+	a := eventAdapters[t.Adapter]
+	event := a.EventType(in)
+	if event == "" {
+		return false, "unrecognized event"
+	}
+	if len(t.Events) > 0 && !slices.Contains(t.Events, event) {
+		return false, fmt.Sprintf("event %q filtered out", event)
+	}
+	if _, err := a.Render(event, in); errors.Is(err, errUnsupportedEvent) {
+		return false, fmt.Sprintf("event %q not supported by adapter %s", event, t.Adapter)
+	}
+	return true, ""
+}
+
+// buildMessage 把入站事件渲染成 IM 消息
+func (t *IMTarget) buildMessage(in *inboundRequest) (im.Message, error) {
+	if t.Adapter != "" {
+		a := eventAdapters[t.Adapter]
+		n, err := a.Render(a.EventType(in), in)
+		if err != nil {
+			return im.Message{}, err
+		}
+		return n.message(t.MsgType)
+	}
+
+	out, err := renderTemplate(t.Template, newTransformData(in))
+	if err != nil {
+		return im.Message{}, fmt.Errorf("render im template: %w", err)
+	}
+	msg := im.Message{Type: t.MsgType}
+	switch t.MsgType {
+	case im.TextMsg:
+		msg.Content = out
+	case im.MarkdownMsg:
+		msg.Content = im.MarkdownContent{Content: out}
+	case im.TextCardMsg:
+		var card struct {
+			Title       string `json:"title"`
+			Description string `json:"description"`
+			URL         string `json:"url"`
+			ButtonText  string `json:"btntxt"`
+		}
+		if err := json.Unmarshal([]byte(out), &card); err != nil {
+			return msg, fmt.Errorf("decode textcard: %w", err)
+		}
+		msg.Content = im.TextCardContent(card)
+	case im.NewsMsg:
+		var news struct {
+			Articles []struct {
+				Title       string `json:"title"`
+				Description string `json:"description"`
+				URL         string `json:"url"`
+				PicURL      string `json:"picurl"`
+			} `json:"articles"`
+		}
+		if err := json.Unmarshal([]byte(out), &news); err != nil {
+			return msg, fmt.Errorf("decode news: %w", err)
+		}
+		content := im.NewsContent{}
+		for _, a := range news.Articles {
+			content.Articles = append(content.Articles, im.NewsArticle(a))
+		}
+		msg.Content = content
+	}
+	return msg, nil
+}
+
+// deliverIM 发送一次 IM 消息并返回尝试记录。消息无法构造，或平台返回无效用户、
+// 应用停用等重试无法解决的错误时 permanent 为 true，见 im.Temporary
+func deliverIM(ctx context.Context, hookID string, t *IMTarget, in *inboundRequest) (attempt Attempt, permanent bool) {
+	attempt = Attempt{Timestamp: time.Now(), URL: t.targetURL()}
+	msg, err := t.buildMessage(in)
+	if err != nil {
+		return attempt.fail(err), true
+	}
+	err = imClientFor(hookID, t).SendMessage(ctx, t.ToUsers, t.ToDepts, msg)
+	attempt.Latency = time.Since(attempt.Timestamp)
+	if err != nil {
+		return attempt.fail(fmt.Errorf("%s: %w", t.Provider, err)), !im.Temporary(err)
+	}
+	attempt.StatusCode = http.StatusOK
+	return attempt, false
+}
+
+// parseIMForm 从表单读取 IM 目标配置
+func parseIMForm(r *http.Request) (*IMTarget, error) {
+	t := &IMTarget{
+		Provider:  r.FormValue("im_provider"),
+		AppID:     strings.TrimSpace(r.FormValue("im_app_id")),
+		AppSecret: strings.TrimSpace(r.FormValue("im_app_secret")),
+		ToUsers:   splitList(r.FormValue("im_to_users")),
+		ToDepts:   splitList(r.FormValue("im_to_depts")),
+		MsgType:   im.MessageType(r.FormValue("im_msg_type")),
+		Template:  r.FormValue("im_template"),
+		Adapter:   r.FormValue("im_adapter"),
+		Events:    splitList(r.FormValue("im_events")),
+	}
+	if v := r.FormValue("im_agent_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid agent id %q", v)
+		}
+		t.AgentID = id
+	}
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limits 入站请求的大小与频率限制，字段为零值时使用全局默认值。
+// 频率限制为令牌桶：每秒补充 Rate 个令牌，最多积攒 Burst 个
+type Limits struct {
+	MaxBodyBytes int64   `json:"max_body_bytes,omitempty" yaml:"max_body_bytes"` // 请求体上限，超过返回 413
+	Rate         float64 `json:"rate,omitempty" yaml:"rate"`                     // 整个 Hook 每秒允许的请求数
+	Burst        int     `json:"burst,omitempty" yaml:"burst"`
+	IPRate       float64 `json:"ip_rate,omitempty" yaml:"ip_rate"` // 同一来源 IP 每秒允许的请求数
+	IPBurst      int     `json:"ip_burst,omitempty" yaml:"ip_burst"`
+}
+
+// merge 用 l 中非零的字段覆盖默认限制
+func (l Limits) merge(def Limits) Limits {
+	if l.MaxBodyBytes > 0 {
+		def.MaxBodyBytes = l.MaxBodyBytes
+	}
+	if l.Rate > 0 {
+		def.Rate, def.Burst = l.Rate, l.Burst
+	}
+	if l.IPRate > 0 {
+		def.IPRate, def.IPBurst = l.IPRate, l.IPBurst
+	}
+	return def
+}
+
+func (l Limits) Validate() error {
+	if l.MaxBodyBytes < 0 || l.Rate < 0 || l.Burst < 0 || l.IPRate < 0 || l.IPBurst < 0 {
+		return errors.New("values must not be negative")
+	}
+	return nil
+}
+
+// burstOf 未设置桶容量时至少允许一秒的请求量
+func burstOf(rate float64, burst int) float64 {
+	if burst > 0 {
+		return float64(burst)
+	}
+	return math.Max(1, math.Ceil(rate))
+}
+
+type bucket struct {
+	rate, burst float64
+	tokens      float64
+	last        time.Time
+}
+
+// refill 按经过的时间补充令牌
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// wait 补充令牌，返回取出一个令牌前需要等待的时间，为 0 表示可以取出
+func (b *bucket) wait(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration(math.Ceil((1 - b.tokens) / b.rate * float64(time.Second)))
+}
+
+// bucketSweepInterval 清理令牌桶的间隔，已补满的桶删除后重建与原来等价
+const bucketSweepInterval = time.Minute
+
+// inboundLimiter 按 Hook 和 Hook+来源 IP 维护令牌桶
+type inboundLimiter struct {
+	defaults Limits
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+func newInboundLimiter(defaults Limits) *inboundLimiter {
+	return &inboundLimiter{defaults: defaults, buckets: make(map[string]*bucket), swept: time.Now()}
+}
+
+// limits 返回 Hook 生效的限制
+func (l *inboundLimiter) limits(h *Hook) Limits {
+	return h.Limits.merge(l.defaults)
+}
+
+// allow 检查来源 IP 与 Hook 的频率限制，先报告 IP 限制，避免单个发送方耗尽整个 Hook 的令牌。
+// 两个桶都有令牌时才各取出一个，被拒绝的请求不消耗令牌。
+// 被限制时返回原因和建议的重试等待时间
+func (l *inboundLimiter) allow(h *Hook, remoteAddr string, now time.Time) (string, time.Duration) {
+	lim := l.limits(h)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	var ipBucket, hookBucket *bucket
+	if lim.IPRate > 0 {
+		ip := remoteIP(remoteAddr)
+		ipBucket = l.bucket("ip:"+h.ID+"/"+ip, lim.IPRate, lim.IPBurst, now)
+		if wait := ipBucket.wait(now); wait > 0 {
+			return fmt.Sprintf("rate limited: source %s exceeds %g req/s", ip, lim.IPRate), wait
+		}
+	}
+	if lim.Rate > 0 {
+		hookBucket = l.bucket("hook:"+h.ID, lim.Rate, lim.Burst, now)
+		if wait := hookBucket.wait(now); wait > 0 {
+			return fmt.Sprintf("rate limited: hook exceeds %g req/s", lim.Rate), wait
+		}
+	}
+	for _, b := range []*bucket{ipBucket, hookBucket} {
+		if b != nil {
+			b.tokens--
+		}
+	}
+	return "", 0
+}
+
+// bucket 返回 key 对应的令牌桶，Hook 修改限制后沿用已有令牌
+func (l *inboundLimiter) bucket(key string, rate float64, burst int, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burstOf(rate, burst), last: now}
+		l.buckets[key] = b
+	}
+	b.rate, b.burst = rate, burstOf(rate, burst)
+	return b
+}
+
+// sweep 删除已补满的令牌桶，调用方需持有锁
+func (l *inboundLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < bucketSweepInterval {
+		return
+	}
+	l.swept = now
+	for k, b := range l.buckets {
+		if b.refill(now); b.tokens >= b.burst {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+func remoteIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// retryAfter 转换为 Retry-After 头的秒数，至少 1 秒
+func retryAfter(d time.Duration) string {
+	return strconv.Itoa(int(math.Max(1, math.Ceil(d.Seconds()))))
+}
+
+// limitLogWindow 超限请求合并为一条日志的时间窗口
+const limitLogWindow = time.Minute
+
+// limitLog 当前窗口内合并超限请求的日志
+type limitLog struct {
+	logID string
+	start time.Time
+}
+
+// limitLogger 按 Hook 和限制类型合并超限请求的日志
+type limitLogger struct {
+	mu   sync.Mutex
+	logs map[string]limitLog
+}
+
+var limitLogs = &limitLogger{logs: make(map[string]limitLog)}
+
+// record 把超限请求计入当前窗口的日志，窗口已结束或日志已被清理时新建一条，返回日志 ID
+func (l *limitLogger) record(s HookStore, hookID, limit, reason string, in *inboundRequest, now time.Time) (string, error) {
+	key := hookID + "/" + limit
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if cur, ok := l.logs[key]; ok && now.Sub(cur.start) < limitLogWindow {
+		err := s.UpdateLog(hookID, cur.logID, func(entry *Log) {
+			entry.Count++
+			entry.Reason = reason
+		})
+		if err == nil || !errors.Is(err, ErrLogNotFound) {
+			return cur.logID, err
+		}
+	}
+	// 顺带清理已结束的窗口，包括已删除 Hook 的记录
+	for k, cur := range l.logs {
+		if now.Sub(cur.start) >= limitLogWindow {
+			delete(l.logs, k)
+		}
+	}
+	entry := newEventLog(in, nil)
+	entry.State = StateRejected
+	entry.Reason = reason
+	entry.Count = 1
+	if err := s.AppendLog(hookID, entry); err != nil {
+		return "", err
+	}
+	l.logs[key] = limitLog{logID: entry.ID, start: now}
+	return entry.ID, nil
+}
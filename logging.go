@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"webhook-proxy/utils"
+)
+
+// LogConfig 日志输出格式与级别
+type LogConfig struct {
+	Format string `yaml:"format"` // text 或 json
+	Level  string `yaml:"level"`  // debug、info、warn、error
+}
+
+func (c LogConfig) Validate() error {
+	if c.Format != "text" && c.Format != "json" {
+		return fmt.Errorf("format %q must be text or json", c.Format)
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Level)); err != nil {
+		return fmt.Errorf("level %q must be debug, info, warn or error", c.Level)
+	}
+	return nil
+}
+
+// newLogger 按配置创建写入 w 的日志，记录中的凭据会被脱敏，见 redactAttr
+func newLogger(cfg LogConfig, w io.Writer) (*slog.Logger, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	var level slog.Level
+	level.UnmarshalText([]byte(cfg.Level))
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redactAttr}
+	var h slog.Handler = slog.NewTextHandler(w, opts)
+	if cfg.Format == "json" {
+		h = slog.NewJSONHandler(w, opts)
+	}
+	return slog.New(contextHandler{h}), nil
+}
+
+// setupLogging 替换默认日志，标准库 log 包的输出同样经过 slog
+func setupLogging(cfg LogConfig) error {
+	logger, err := newLogger(cfg, os.Stderr)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// fatal 记录错误后退出
+func fatal(err error) {
+	slog.Error(err.Error())
+	os.Exit(1)
+}
+
+// contextHandler 把 ctx 中的请求 ID 加入每条记录，IM 客户端等只拿到 ctx 的代码也能关联请求
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := utils.RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// secretKeys 值整体视为凭据的字段名
+var secretKeys = map[string]bool{
+	"app_secret":    true,
+	"secret":        true,
+	"password":      true,
+	"token":         true,
+	"access_token":  true,
+	"authorization": true,
+}
+
+// redactAttr 隐藏凭据字段，并替换消息、字符串和错误中查询参数形式的凭据
+// （如企业微信、钉钉接口地址中的 access_token、corpsecret、appsecret）
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	if secretKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, utils.Redacted)
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(utils.RedactURL(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			a.Value = slog.StringValue(utils.RedactURL(err.Error()))
+		}
+	}
+	return a
+}
+
+// maxRequestIDLen 调用方传入的 X-Request-ID 长度上限
+const maxRequestIDLen = 128
+
+// requestID 沿用调用方传入的 X-Request-ID，缺失或含有非可见字符时生成新的 ID
+func requestID(r *http.Request) string {
+	id := r.Header.Get("X-Request-ID")
+	if id == "" || len(id) > maxRequestIDLen {
+		return utils.RandomHex(8)
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return utils.RandomHex(8)
+		}
+	}
+	return id
+}
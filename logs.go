@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Retention 日志保留策略，字段为零值时使用全局默认值
+type Retention struct {
+	MaxCount int           `json:"max_count,omitempty" yaml:"max_count"` // 最多保留的日志条数
+	MaxAge   time.Duration `json:"max_age,omitempty" yaml:"max_age"`     // 超过该时长的日志被清理
+}
+
+// merge 用 r 中非零的字段覆盖默认策略
+func (r Retention) merge(def Retention) Retention {
+	if r.MaxCount > 0 {
+		def.MaxCount = r.MaxCount
+	}
+	if r.MaxAge > 0 {
+		def.MaxAge = r.MaxAge
+	}
+	return def
+}
+
+// apply 按条数和时长裁剪日志，logs 需按时间倒序排列
+func (r Retention) apply(logs []Log, now time.Time) []Log {
+	if r.MaxAge > 0 {
+		cutoff := now.Add(-r.MaxAge)
+		n := len(logs)
+		for n > 0 && logs[n-1].Timestamp.Before(cutoff) {
+			n--
+		}
+		logs = logs[:n]
+	}
+	if r.MaxCount > 0 && len(logs) > r.MaxCount {
+		logs = logs[:r.MaxCount]
+	}
+	return logs
+}
+
+// LogQuery 日志查询条件，各字段为零值时不过滤
+type LogQuery struct {
+	Limit       int       // 每页条数，<=0 表示不限制
+	Cursor      string    // 上一页返回的 next_cursor
+	StatusClass int       // 1-5，对应 1xx-5xx；投递未获得响应的日志状态码为 0
+	State       string    // 投递状态，如 dead
+	Since       time.Time // 起始时间（含）
+	Until       time.Time // 截止时间（不含）
+	Contains    string    // 请求体包含的子串
+}
+
+func (q LogQuery) match(entry Log) bool {
+	if q.StatusClass > 0 && entry.StatusCode/100 != q.StatusClass {
+		return false
+	}
+	if q.State != "" && entry.State != q.State {
+		return false
+	}
+	if !q.Since.IsZero() && entry.Timestamp.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !entry.Timestamp.Before(q.Until) {
+		return false
+	}
+	if q.Contains != "" && !strings.Contains(entry.Body, q.Contains) {
+		return false
+	}
+	return true
+}
+
+// page 在按时间倒序排列的日志上执行过滤与分页，返回本页日志和下一页游标
+func (q LogQuery) page(logs []Log) ([]Log, string, error) {
+	start := 0
+	if q.Cursor != "" {
+		ts, id, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		// 跳过游标及其之前（更新）的日志；游标对应的日志即使已被清理也能按时间定位
+		for start < len(logs) {
+			entry := logs[start]
+			start++
+			if entry.ID == id {
+				break
+			}
+			if entry.Timestamp.Before(ts) {
+				start--
+				break
+			}
+		}
+	}
+
+	page := []Log{}
+	for i := start; i < len(logs); i++ {
+		if !q.match(logs[i]) {
+			continue
+		}
+		if q.Limit > 0 && len(page) == q.Limit {
+			last := page[len(page)-1]
+			return page, encodeCursor(last.Timestamp, last.ID), nil
+		}
+		page = append(page, logs[i])
+	}
+	return page, "", nil
+}
+
+func encodeCursor(ts time.Time, id string) string {
+	raw := fmt.Sprintf("%d:%s", ts.UnixNano(), id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	ns, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(ns, 10, 64)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	return time.Unix(0, n), id, nil
+}
+
+// parseStatusClass 解析 "5xx"、"5" 形式的状态码类别
+func parseStatusClass(s string) (int, error) {
+	s = strings.TrimSuffix(strings.ToLower(s), "xx")
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > 5 {
+		return 0, fmt.Errorf("invalid status class %q", s)
+	}
+	return n, nil
+}
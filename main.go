@@ -1,68 +1,282 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"html/template"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
+	"webhook-proxy/utils"
 )
 
 type Hook struct {
-	TargetURL string
-	Logs      []Log
+	ID      string   `json:"id"`
+	Targets []Target `json:"targets"` // 事件并行投递到全部目标
+	// TargetURL 旧版本的单目标字段，加载时迁移到 Targets
+	TargetURL string        `json:"target_url,omitempty"`
+	Forward   ForwardConfig `json:"forward"`
+	Transform *Transform    `json:"transform,omitempty"`
+	Verify    *Verification `json:"verify,omitempty"`  // 非空时校验入站请求签名
+	Signing   *Signing      `json:"signing,omitempty"` // 非空时为转发请求签名
+	IM        *IMTarget     `json:"im,omitempty"`      // 非空时事件发送到 IM，而不是 Targets
+	Retry     *RetryPolicy  `json:"retry,omitempty"`   // 为空时使用全局重试策略
+	Retention Retention     `json:"retention"`
+	Limits    Limits        `json:"limits"`
+	Filter    *Filter       `json:"filter,omitempty"` // 非空时只转发满足条件的事件
+	// ClientCert 非空时要求调用方出示客户端证书，需要服务以 TLS 运行并配置 client_ca_file
+	ClientCert *ClientCertPolicy `json:"client_cert,omitempty"`
+	Paused     bool              `json:"paused,omitempty"`     // 暂停时事件只记录不转发
+	Owner      string            `json:"owner,omitempty"`      // 创建者，见 User.canAccess
+	Team       string            `json:"team,omitempty"`       // 所属团队，团队成员与所有者权限相同
+	TokenHash  string            `json:"token_hash,omitempty"` // 管理令牌的 SHA-256，见 manage.go
+	CreatedAt  time.Time         `json:"created_at"`
 }
 
+// Target 投递目标
+type Target struct {
+	Name string `json:"name,omitempty"`
+	URL  string `json:"url"`
+}
+
+// Log 一次入站事件及其在各目标上的投递记录
 type Log struct {
-	Timestamp  time.Time `json:"timestamp"`
-	Body       string    `json:"body"`
-	StatusCode int       `json:"status_code"`
+	ID         string      `json:"id"`
+	Timestamp  time.Time   `json:"timestamp"`
+	Method     string      `json:"method"`
+	Query      string      `json:"query,omitempty"`
+	Host       string      `json:"host"`
+	Headers    http.Header `json:"headers"`
+	RemoteAddr string      `json:"remote_addr"`
+	TLS        bool        `json:"tls,omitempty"`
+	Body       string      `json:"body"`
+	ReplayOf   string      `json:"replay_of,omitempty"`  // 重放事件对应的原始事件 ID
+	RequestID  string      `json:"request_id,omitempty"` // 入站请求的 X-Request-ID，与服务日志对应
+	State      string      `json:"state"`                // 各目标投递状态的汇总，见 summarize
+	Reason     string      `json:"reason,omitempty"`     // 事件被跳过或拒绝的原因
+	Count      int         `json:"count,omitempty"`      // 合并记录的超限请求数，见 limitLogs
+	StatusCode int         `json:"status_code"`          // 优先取失败目标最近一次的响应状态码
+	Error      string      `json:"error,omitempty"`
+	Deliveries []Delivery  `json:"deliveries"`
+}
+
+// Delivery 事件在单个目标上的投递状态
+type Delivery struct {
+	Target        string     `json:"target"`
+	State         string     `json:"state"`
+	StatusCode    int        `json:"status_code"`
+	Error         string     `json:"error,omitempty"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"` // 等待重试时的下次投递时间
+	Attempts      []Attempt  `json:"attempts"`
+}
+
+// Attempt 一次投递尝试的记录
+type Attempt struct {
+	Number          int           `json:"number"`
+	Timestamp       time.Time     `json:"timestamp"`
+	URL             string        `json:"url"`
+	StatusCode      int           `json:"status_code"`
+	ResponseHeaders http.Header   `json:"response_headers,omitempty"`
+	ResponseBody    string        `json:"response_body,omitempty"`
+	Truncated       bool          `json:"truncated,omitempty"` // 响应体超过 maxResponseBody 被截断
+	Latency         time.Duration `json:"latency_ns"`
+	Error           string        `json:"error,omitempty"`
 }
 
 var (
-	hooks = make(map[string]*Hook)
-	mu    sync.Mutex
+	store   HookStore
+	queue   *DeliveryQueue
+	limiter *inboundLimiter
 )
 
 func main() {
-	http.HandleFunc("/", indexHandler)
+	cfg, err := loadConfig(os.Args)
+	if err != nil {
+		fatal(err)
+	}
+	if err := setupLogging(cfg.Log); err != nil {
+		fatal(err)
+	}
+
+	s, err := NewHookStore(cfg.Store.Kind, cfg.Store.Path, cfg.Retention)
+	if err != nil {
+		fatal(err)
+	}
+	store = &publishingStore{HookStore: s, broker: logStream}
+	if err := reconcileHooks(cfg.Hooks); err != nil {
+		fatal(err)
+	}
+
+	queue = NewDeliveryQueue(store, cfg.Queue.Workers, cfg.Queue.Size, cfg.Retry)
+	limiter = newInboundLimiter(cfg.Limits)
+
+	http.HandleFunc("/", dashboardHandler)
+	http.HandleFunc("/new", newHookPageHandler)
+	http.HandleFunc("/dashboard/", hookPageHandler)
 	http.HandleFunc("/create", createHandler)
 	http.HandleFunc("/hook/", hookHandler)
 	http.HandleFunc("/logs/", logsHandler)
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
-
-	port := ":8080"
-	fmt.Println("Listening on", port)
-	log.Fatal(http.ListenAndServe(port, nil))
-}
+	http.HandleFunc("/deadletters/", deadLettersHandler)
+	http.HandleFunc("/replay/", replayHandler)
+	http.HandleFunc("/preview/", previewHandler)
+	http.HandleFunc("/signing/", signingHandler)
+	http.HandleFunc("/manage/", manageHandler)
+	http.HandleFunc("/api/v1/", apiHandler)
+	http.HandleFunc("/login", loginHandler)
+	http.HandleFunc("/logout", logoutHandler)
+	http.HandleFunc("/register", registerHandler)
+	http.HandleFunc("/metrics", metricsHandler(cfg.MetricsToken))
+	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(cfg.StaticDir))))
 
-func indexHandler(w http.ResponseWriter, r *http.Request) {
-	tmpl := template.Must(template.ParseFiles("templates/index.html"))
-	tmpl.Execute(w, nil)
+	if err := run(cfg, newServer(cfg, http.DefaultServeMux)); err != nil {
+		fatal(err)
+	}
 }
 
 func createHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
 	r.ParseForm()
-	target := r.FormValue("target_url")
-	if target == "" {
-		http.Error(w, "请输入目标 URL", http.StatusBadRequest)
+	team := strings.TrimSpace(r.FormValue("team"))
+	if err := checkTeam(user, team); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
+	var targets []Target
+	var imTarget *IMTarget
+	if r.FormValue("hook_type") == "im" {
+		var err error
+		if imTarget, err = parseIMForm(r); err != nil {
+			http.Error(w, "IM 配置无效："+err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		for _, u := range strings.Fields(r.FormValue("target_url")) {
+			targets = append(targets, Target{URL: u})
+		}
+		if len(targets) == 0 {
+			http.Error(w, "请输入目标 URL", http.StatusBadRequest)
+			return
+		}
+	}
 
-	id := fmt.Sprintf("%d", time.Now().UnixNano())
+	id := newHookID()
+	token, tokenHash := newManagementToken()
 
-	mu.Lock()
-	hooks[id] = &Hook{TargetURL: target}
-	mu.Unlock()
+	hook := &Hook{
+		ID:        id,
+		Owner:     user.Name,
+		Team:      team,
+		TokenHash: tokenHash,
+		Targets:   targets,
+		IM:        imTarget,
+		Forward: ForwardConfig{
+			Faithful:     r.FormValue("faithful") != "",
+			AllowHeaders: splitList(r.FormValue("allow_headers")),
+			DenyHeaders:  splitList(r.FormValue("deny_headers")),
+		},
+		CreatedAt: time.Now(),
+	}
+	if v := r.FormValue("max_retries"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "重试次数无效", http.StatusBadRequest)
+			return
+		}
+		p := queue.policy
+		p.MaxRetries = n
+		hook.Retry = &p
+	}
+	if v := r.FormValue("retention_count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "日志保留条数无效", http.StatusBadRequest)
+			return
+		}
+		hook.Retention.MaxCount = n
+	}
+	if v := r.FormValue("retention_age"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			http.Error(w, "日志保留时长无效", http.StatusBadRequest)
+			return
+		}
+		hook.Retention.MaxAge = d
+	}
+	if v := r.FormValue("max_body"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, "请求体上限无效", http.StatusBadRequest)
+			return
+		}
+		hook.Limits.MaxBodyBytes = n
+	}
+	if v := r.FormValue("rate"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 {
+			http.Error(w, "请求频率限制无效", http.StatusBadRequest)
+			return
+		}
+		hook.Limits.Rate = f
+	}
+	if v := r.FormValue("ip_rate"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 {
+			http.Error(w, "来源 IP 频率限制无效", http.StatusBadRequest)
+			return
+		}
+		hook.Limits.IPRate = f
+	}
+	transform, err := parseTransformForm(r)
+	if err != nil {
+		http.Error(w, "转换模板无效："+err.Error(), http.StatusBadRequest)
+		return
+	}
+	hook.Transform = transform
+	if hook.Verify, err = parseVerifyForm(r); err != nil {
+		http.Error(w, "签名校验配置无效："+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if hook.Signing, err = parseSigningForm(r); err != nil {
+		http.Error(w, "签名密钥无效："+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.FormValue("client_cert") != "" {
+		hook.ClientCert = &ClientCertPolicy{Required: true, Names: splitList(r.FormValue("client_cert_names"))}
+	}
+	if hook.Filter, err = parseFilterForm(r); err != nil {
+		http.Error(w, "转发条件无效："+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := hook.validate(); err != nil {
+		http.Error(w, "配置无效："+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = store.CreateHook(hook)
+	if err != nil {
+		http.Error(w, "创建失败："+err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	resp := fmt.Sprintf(`✅ Webhook 已创建！
 
 📥 请求地址：/hook/%s  
-📊 日志查看：/logs/%s`, id, id)
+📊 日志查看：/logs/%s
+🔑 管理令牌：%s（仅显示一次，用于修改、暂停和删除）`, id, id, token)
+	if hook.Signing != nil {
+		resp += "\n🔏 签名密钥：" + hook.Signing.Secret
+	}
 
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.Write([]byte(resp))
@@ -70,53 +284,357 @@ func createHandler(w http.ResponseWriter, r *http.Request) {
 
 func hookHandler(w http.ResponseWriter, r *http.Request) {
 	id := strings.TrimPrefix(r.URL.Path, "/hook/")
-	mu.Lock()
-	hook, exists := hooks[id]
-	mu.Unlock()
-
-	if !exists {
+	reqID := requestID(r)
+	w.Header().Set("X-Request-ID", reqID)
+	r = r.WithContext(utils.WithRequestID(r.Context(), reqID))
+	hook, err := store.GetHook(id)
+	if errors.Is(err, ErrHookNotFound) {
 		http.Error(w, "Webhook 不存在", http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	body, _ := io.ReadAll(r.Body)
+	if reason, wait := limiter.allow(hook, r.RemoteAddr, time.Now()); reason != "" {
+		w.Header().Set("Retry-After", retryAfter(wait))
+		limitEvent(w, r, id, "rate", reason, http.StatusTooManyRequests)
+		return
+	}
+	if hook.ClientCert != nil {
+		if err := hook.ClientCert.check(r.TLS); err != nil {
+			rejectEvent(w, id, captureInbound(r, nil), err.Error())
+			return
+		}
+	}
 	defer r.Body.Close()
-
-	resp, err := http.Post(hook.TargetURL, "application/json", strings.NewReader(string(body)))
-	status := 0
+	maxBody := limiter.limits(hook).MaxBodyBytes
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		limitEvent(w, r, id, "size", fmt.Sprintf("body too large: exceeds %d bytes", maxBody), http.StatusRequestEntityTooLarge)
+		return
+	}
 	if err != nil {
-		status = 500
-	} else {
-		status = resp.StatusCode
+		http.Error(w, "读取请求体失败："+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	in := captureInbound(r, body)
+	if hook.Verify != nil {
+		if err := hook.Verify.verify(in, time.Now()); err != nil {
+			rejectEvent(w, id, in, err.Error())
+			return
+		}
+	}
+	if hook.Paused {
+		skipEvent(w, id, in, "hook paused")
+		return
+	}
+	if !hook.Filter.passes(in) {
+		skipEvent(w, id, in, "filtered out by hook filter")
+		return
+	}
+	if hook.IM != nil {
+		if ok, reason := hook.IM.accepts(in); !ok {
+			skipEvent(w, id, in, reason)
+			return
+		}
+	}
+
+	logEntry := newEventLog(in, hook.targetURLs())
+	if err := store.AppendLog(id, logEntry); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	results := queue.EnqueueEvent(id, logEntry, in)
+	status := http.StatusServiceUnavailable
+	for _, d := range results {
+		if d.State == StatePending {
+			status = http.StatusAccepted
+		}
 	}
 
-	logEntry := Log{
-		Timestamp:  time.Now(),
-		Body:       string(body),
-		StatusCode: status,
+	inboundRequests.inc(id, StatePending)
+	slog.Info("event accepted", "hook", id, "event", logEntry.ID, "request_id", reqID,
+		"remote_addr", in.RemoteAddr, "targets", len(results))
+	resp := hookResponse{EventID: logEntry.ID, State: StatePending}
+	for _, d := range results {
+		resp.Targets = append(resp.Targets, targetResult{URL: d.Target, State: d.State, Error: d.Error})
 	}
+	writeHookResponse(w, status, resp)
+}
+
+type targetResult struct {
+	URL   string `json:"url"`
+	State string `json:"state"`
+	Error string `json:"error,omitempty"`
+}
+
+// hookResponse 返回给 Webhook 调用方的处理结果
+type hookResponse struct {
+	EventID string         `json:"event_id"`
+	State   string         `json:"state"`
+	Reason  string         `json:"reason,omitempty"`
+	Targets []targetResult `json:"targets,omitempty"`
+}
+
+func writeHookResponse(w http.ResponseWriter, status int, resp hookResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// skipEvent 记录未转发的事件并告知调用方，返回 200 以免发送方重试
+func skipEvent(w http.ResponseWriter, hookID string, in *inboundRequest, reason string) {
+	discardEvent(w, hookID, in, StateSkipped, reason, http.StatusOK)
+}
+
+// rejectEvent 记录未通过签名校验的请求，返回 401
+func rejectEvent(w http.ResponseWriter, hookID string, in *inboundRequest, reason string) {
+	discardEvent(w, hookID, in, StateRejected, "verification failed: "+reason, http.StatusUnauthorized)
+}
 
-	mu.Lock()
-	hook.Logs = append([]Log{logEntry}, hook.Logs...)
-	if len(hook.Logs) > 10 {
-		hook.Logs = hook.Logs[:10]
+// limitEvent 拒绝超出大小或频率限制的请求。同一 Hook、同一种限制在 limitLogWindow 内
+// 被拒绝的请求合并为一条 rejected 日志并累加次数，突发流量不会逐条写入日志，请求体不会保存
+func limitEvent(w http.ResponseWriter, r *http.Request, hookID, limit, reason string, status int) {
+	inboundRequests.inc(hookID, StateRejected)
+	inboundLimited.inc(hookID, limit)
+	in := captureInbound(r, nil)
+	id, err := limitLogs.record(store, hookID, limit, reason, in, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.DebugContext(r.Context(), "request limited", "hook", hookID, "event", id, "remote_addr", r.RemoteAddr, "reason", reason)
+	writeHookResponse(w, status, hookResponse{EventID: id, State: StateRejected, Reason: reason})
+}
+
+func discardEvent(w http.ResponseWriter, hookID string, in *inboundRequest, state, reason string, status int) {
+	entry := newEventLog(in, nil)
+	entry.State = state
+	entry.Reason = reason
+	if err := store.AppendLog(hookID, entry); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	mu.Unlock()
+	inboundRequests.inc(hookID, state)
+	level := slog.LevelInfo
+	if state == StateRejected {
+		level = slog.LevelWarn
+	}
+	slog.Log(context.Background(), level, "event "+state, "hook", hookID, "event", entry.ID, "request_id", in.RequestID,
+		"remote_addr", in.RemoteAddr, "reason", reason)
+	writeHookResponse(w, status, hookResponse{EventID: entry.ID, State: state, Reason: reason})
+}
 
-	w.Write([]byte(fmt.Sprintf("转发完成，状态码：%d", status)))
+// targetURLs 返回全部投递目标地址，IM Hook 只有一个 im:// 目标
+func (h *Hook) targetURLs() []string {
+	if h.IM != nil {
+		return []string{h.IM.targetURL()}
+	}
+	urls := make([]string, 0, len(h.Targets))
+	for _, t := range h.Targets {
+		urls = append(urls, t.URL)
+	}
+	return urls
 }
 
+// logsHandler 分页查询日志，支持的参数：
+//
+//	limit   每页条数，默认 50，最大 500
+//	cursor  上一页返回的 next_cursor
+//	status  状态码类别，如 5xx
+//	state   投递状态，如 dead
+//	since   起始时间（RFC3339）
+//	until   截止时间（RFC3339）
+//	q       请求体包含的子串
+//
+// GET /logs/{hookID}/stream 以 SSE 实时推送新日志与投递更新，见 streamLogs
 func logsHandler(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimPrefix(r.URL.Path, "/logs/")
-	mu.Lock()
-	hook, exists := hooks[id]
-	mu.Unlock()
+	id, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/logs/"), "/")
+	if sub != "" && sub != "stream" {
+		http.NotFound(w, r)
+		return
+	}
+	if authorizeHook(w, r, id) == nil {
+		return
+	}
+	if sub == "stream" {
+		streamLogs(w, r, id)
+		return
+	}
+	q, err := parseLogQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, "查询参数无效："+err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	if !exists {
+	logs, next, err := store.QueryLogs(id, q)
+	if errors.Is(err, ErrHookNotFound) {
 		http.Error(w, "Webhook 不存在", http.StatusNotFound)
 		return
 	}
+	if errors.Is(err, ErrInvalidCursor) {
+		http.Error(w, "cursor 无效", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(hook.Logs)
-}
\ No newline at end of file
+	json.NewEncoder(w).Encode(struct {
+		Logs       []Log  `json:"logs"`
+		NextCursor string `json:"next_cursor,omitempty"`
+	}{logs, next})
+}
+
+func parseLogQuery(v url.Values) (LogQuery, error) {
+	q := LogQuery{
+		Limit:    50,
+		Cursor:   v.Get("cursor"),
+		State:    v.Get("state"),
+		Contains: v.Get("q"),
+	}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return q, fmt.Errorf("invalid limit %q", s)
+		}
+		q.Limit = min(n, 500)
+	}
+	if s := v.Get("status"); s != "" {
+		class, err := parseStatusClass(s)
+		if err != nil {
+			return q, err
+		}
+		q.StatusClass = class
+	}
+	for name, t := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		if s := v.Get(name); s != "" {
+			parsed, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return q, fmt.Errorf("invalid %s %q", name, s)
+			}
+			*t = parsed
+		}
+	}
+	return q, nil
+}
+
+// deadLettersHandler 查看与重新投递死信：
+//
+//	GET  /deadletters/{hookID}                   列出死信
+//	POST /deadletters/{hookID}/redrive           重新投递全部死信
+//	POST /deadletters/{hookID}/{eventID}/redrive 重新投递单条死信
+func deadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/deadletters/"), "/")
+	id := parts[0]
+	if authorizeHook(w, r, id) == nil {
+		return
+	}
+
+	if len(parts) == 1 {
+		list, err := store.ListDeadLetters(id)
+		if errors.Is(err, ErrHookNotFound) {
+			http.Error(w, "Webhook 不存在", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// 死信保留原始请求以便重新投递，展示时同样脱敏
+		for i, dl := range list {
+			if dl.Request != nil {
+				req := *dl.Request
+				req.Header = redactHeaders(req.Header)
+				list[i].Request = &req
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+
+	if parts[len(parts)-1] != "redrive" || len(parts) > 3 {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "请使用 POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var eventIDs []string
+	if len(parts) == 3 {
+		eventIDs = []string{parts[1]}
+	} else {
+		list, err := store.ListDeadLetters(id)
+		if errors.Is(err, ErrHookNotFound) {
+			http.Error(w, "Webhook 不存在", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, dl := range list {
+			eventIDs = append(eventIDs, dl.EventID)
+		}
+	}
+
+	redriven := 0
+	for _, eventID := range eventIDs {
+		err := redrive(id, eventID)
+		if errors.Is(err, ErrHookNotFound) || errors.Is(err, ErrDeadLetterNotFound) {
+			http.Error(w, "死信不存在", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		redriven++
+	}
+
+	w.Write([]byte(fmt.Sprintf("已重新投递 %d 条事件", redriven)))
+}
+
+// redrive 将死信移出并重新入队，对应目标的投递记录恢复为 pending
+func redrive(hookID, eventID string) error {
+	dl, err := store.RemoveDeadLetter(hookID, eventID)
+	if err != nil {
+		return err
+	}
+
+	err = updateDelivery(store, hookID, dl.EventID, dl.TargetURL, func(d *Delivery) {
+		d.State = StatePending
+		d.Error = ""
+	})
+	if errors.Is(err, ErrLogNotFound) {
+		// 原日志已被淘汰，补一条新日志
+		entry := newEventLog(dl.Request, []string{dl.TargetURL})
+		entry.ID = dl.EventID
+		err = store.AppendLog(hookID, entry)
+	}
+	if err != nil {
+		store.AddDeadLetter(hookID, dl)
+		return err
+	}
+
+	job := &deliveryJob{HookID: hookID, EventID: dl.EventID, Request: dl.Request, TargetURL: dl.TargetURL}
+	if err := queue.Enqueue(job); err != nil {
+		store.AddDeadLetter(hookID, dl)
+		updateDelivery(store, hookID, dl.EventID, dl.TargetURL, func(d *Delivery) {
+			d.State = StateDead
+			d.Error = err.Error()
+		})
+		return err
+	}
+	return nil
+}
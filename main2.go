@@ -1,6 +1,10 @@
+//go:build ignore
+
+// 企业微信 SDK 的使用示例，不参与构建：go run main2.go
 package main
 
 import (
+	"context"
 	"fmt"
 	"webhook-proxy/im"
 	"webhook-proxy/im/wecom"
@@ -10,7 +14,7 @@ func main() {
 	client := wecom.NewWeComClient("your_corp_id", "your_corp_secret")
 
 	// 发送文本消息
-	err := client.SendMessage([]string{"userid1", "userid2"}, nil, im.Message{
+	err := client.SendMessage(context.Background(), []string{"userid1", "userid2"}, nil, im.Message{
 		Type:    im.TextMsg,
 		Content: "Hello from Go SDK!",
 	})
@@ -21,7 +25,7 @@ func main() {
 	}
 
 	// 获取部门
-	depts, err := client.GetDepartments()
+	depts, err := client.GetDepartments(context.Background())
 	if err != nil {
 		fmt.Println("GetDepartments error:", err)
 	} else {
@@ -29,7 +33,7 @@ func main() {
 	}
 
 	// 获取用户
-	users, err := client.GetUsers()
+	users, err := client.GetUsers(context.Background())
 	if err != nil {
 		fmt.Println("GetUsers error:", err)
 	} else {
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"webhook-proxy/utils"
+)
+
+// newHookID 生成不可预测的 Hook ID
+func newHookID() string {
+	return utils.RandomHex(16)
+}
+
+// newManagementToken 生成管理令牌，只保存其哈希，明文仅在创建时返回一次
+func newManagementToken() (token, hash string) {
+	token = utils.RandomHex(32)
+	return token, hashToken(token)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkToken 校验管理令牌。早期创建的 Hook 没有令牌，无法通过校验
+func (h *Hook) checkToken(token string) bool {
+	if h.TokenHash == "" || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(h.TokenHash)) == 1
+}
+
+// requestToken 从 Authorization: Bearer 请求头或 token 参数读取管理令牌
+func requestToken(r *http.Request) string {
+	if v, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(v)
+	}
+	return r.FormValue("token")
+}
+
+// authorizeHook 读取 Hook 并校验访问权限（见 hookAccess），失败时写入错误响应并返回 nil
+func authorizeHook(w http.ResponseWriter, r *http.Request, id string) *Hook {
+	hook, status, err := hookAccess(r, id)
+	switch status {
+	case http.StatusOK:
+		return hook
+	case http.StatusNotFound:
+		http.Error(w, "Webhook 不存在", status)
+	case http.StatusUnauthorized:
+		w.Header().Set("WWW-Authenticate", `Bearer realm="webhook-proxy"`)
+		http.Error(w, "请登录或提供管理令牌", status)
+	case http.StatusForbidden:
+		http.Error(w, "无权访问该 Webhook", status)
+	default:
+		http.Error(w, err.Error(), status)
+	}
+	return nil
+}
+
+// manageHandler 修改或删除 Hook，需要登录或使用创建时返回的管理令牌：
+//
+//	POST   /manage/{hookID}/update  target_url 替换全部目标地址，paused=true/false 暂停或恢复转发，team 修改所属团队（需要登录）
+//	POST   /manage/{hookID}/delete  删除 Hook 及其日志、死信
+//	DELETE /manage/{hookID}         同上
+func manageHandler(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/manage/"), "/")
+	if r.Method == http.MethodDelete && action == "" {
+		action = "delete"
+	} else if r.Method != http.MethodPost {
+		http.Error(w, "请使用 POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if action != "update" && action != "delete" {
+		http.NotFound(w, r)
+		return
+	}
+	hook := authorizeHook(w, r, id)
+	if hook == nil {
+		return
+	}
+
+	if action == "delete" {
+		if err := store.DeleteHook(id); err != nil && !errors.Is(err, ErrHookNotFound) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		forgetIMClient(id)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if v := r.FormValue("target_url"); v != "" {
+		if hook.IM != nil {
+			http.Error(w, "IM Webhook 没有目标地址", http.StatusBadRequest)
+			return
+		}
+		var targets []Target
+		for _, u := range strings.Fields(v) {
+			targets = append(targets, Target{URL: u})
+		}
+		hook.Targets = targets
+	}
+	if v := r.FormValue("paused"); v != "" {
+		paused, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "paused 无效", http.StatusBadRequest)
+			return
+		}
+		hook.Paused = paused
+	}
+	if v, ok := r.Form["team"]; ok {
+		team := strings.TrimSpace(v[0])
+		// 只持有管理令牌的调用方不能修改团队，否则可以把 Hook 交给任意团队
+		u := currentUser(r)
+		if u == nil && team != hook.Team {
+			http.Error(w, "修改团队需要登录", http.StatusForbidden)
+			return
+		}
+		if u != nil {
+			if err := checkTeam(u, team); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		}
+		hook.Team = team
+	}
+	if err := hook.validate(); err != nil {
+		http.Error(w, "配置无效："+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := store.UpdateHook(hook); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		ID      string   `json:"id"`
+		Targets []Target `json:"targets"`
+		Paused  bool     `json:"paused"`
+		Team    string   `json:"team,omitempty"`
+	}{hook.ID, hook.Targets, hook.Paused, hook.Team})
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"webhook-proxy/im"
+)
+
+// 以 Prometheus 文本格式输出的指标，只实现本服务用到的计数器、直方图和即时值
+
+type collector interface {
+	collect(w io.Writer)
+}
+
+// labelKey 以不会出现在标签值中的分隔符拼接标签值
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+func seriesLabels(names []string, key string, extra ...string) string {
+	var pairs []string
+	if len(names) > 0 {
+		for i, v := range strings.Split(key, "\xff") {
+			pairs = append(pairs, names[i]+`="`+escapeLabel(v)+`"`)
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type counterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+func (c *counterVec) inc(values ...string) {
+	c.mu.Lock()
+	c.values[labelKey(values)]++
+	c.mu.Unlock()
+}
+
+func (c *counterVec) collect(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, seriesLabels(c.labels, k), formatFloat(c.values[k]))
+	}
+}
+
+type histogram struct {
+	counts []uint64 // 与 buckets 一一对应，不累计
+	sum    float64
+	count  uint64
+}
+
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogram)}
+}
+
+func (h *histogramVec) observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := labelKey(values)
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i, _ := slices.BinarySearch(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *histogramVec) collect(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		s := h.series[k]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, seriesLabels(h.labels, k, "le", formatFloat(le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, seriesLabels(h.labels, k, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, seriesLabels(h.labels, k), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, seriesLabels(h.labels, k), s.count)
+	}
+}
+
+// gaugeFunc 在采集时读取当前值
+type gaugeFunc struct {
+	name, help string
+	fn         func() float64
+}
+
+func (g gaugeFunc) collect(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, g.help, g.name, g.name, formatFloat(g.fn()))
+}
+
+// latencyBuckets 投递耗时直方图的上界（秒），最大值与 forwardClient 的超时一致
+var latencyBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+var (
+	inboundRequests = newCounterVec("webhook_inbound_requests_total",
+		"Inbound webhook requests by hook and resulting state.", "hook", "state")
+	inboundLimited = newCounterVec("webhook_inbound_limited_total",
+		"Inbound requests rejected by size or rate limits, by hook and limit.", "hook", "limit")
+	deliveryAttempts = newCounterVec("webhook_delivery_attempts_total",
+		"Delivery attempts by hook, outcome and response status class.", "hook", "outcome", "status_class")
+	deliveryLatency = newHistogramVec("webhook_delivery_duration_seconds",
+		"Duration of delivery attempts.", latencyBuckets, "hook")
+	imMessages = newCounterVec("im_send_message_total",
+		"IM SendMessage calls by provider, message type and result.", "provider", "msg_type", "result")
+	imErrors = newCounterVec("im_api_errors_total",
+		"Error codes returned by IM provider APIs.", "provider", "code")
+	imTokenRefreshes = newCounterVec("im_token_refreshes_total",
+		"Access token refreshes by provider and result.", "provider", "result")
+)
+
+var collectors = []collector{
+	inboundRequests,
+	inboundLimited,
+	deliveryAttempts,
+	deliveryLatency,
+	gaugeFunc{"webhook_delivery_queue_depth", "Delivery jobs waiting in the queue.", func() float64 {
+		return float64(queue.Len())
+	}},
+	gaugeFunc{"webhook_delivery_retries_scheduled", "Delivery jobs waiting for a retry backoff.", func() float64 {
+		return float64(queue.Waiting())
+	}},
+	imMessages,
+	imErrors,
+	imTokenRefreshes,
+}
+
+// statusClass 把状态码归为 2xx、4xx 等，没有响应（网络错误、渲染失败）时为 none
+func statusClass(code int) string {
+	if code == 0 {
+		return "none"
+	}
+	return strconv.Itoa(code/100) + "xx"
+}
+
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// imMetrics 实现 im.Metrics
+type imMetrics struct{}
+
+func (imMetrics) MessageSent(provider string, msgType im.MessageType, err error) {
+	imMessages.inc(provider, string(msgType), resultLabel(err))
+	if code, ok := im.ErrorCode(err); ok {
+		imErrors.inc(provider, strconv.Itoa(code))
+	}
+}
+
+func (imMetrics) TokenRefreshed(provider string, err error) {
+	imTokenRefreshes.inc(provider, resultLabel(err))
+}
+
+func init() {
+	im.SetMetrics(imMetrics{})
+}
+
+// metricsHandler 以 Prometheus 文本格式输出指标。指标中含有 Hook ID，
+// 需要管理员的 API Key 或配置的 metrics_token
+func metricsHandler(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bearer, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		allowed := token != "" && subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1
+		if u := currentUser(r); u != nil && u.Admin {
+			allowed = true
+		}
+		if !allowed {
+			http.Error(w, "需要管理员权限或 metrics token", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		for _, c := range collectors {
+			c.collect(bw)
+		}
+		bw.Flush()
+	}
+}
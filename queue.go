@@ -0,0 +1,458 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+	"webhook-proxy/utils"
+)
+
+// 事件投递状态
+const (
+	StatePending   = "pending"
+	StateRetrying  = "retrying"
+	StateDelivered = "delivered"
+	StateDead      = "dead"
+	StatePartial   = "partial"  // 仅用于事件汇总：部分目标成功、部分进入死信
+	StateSkipped   = "skipped"  // 事件未转发，原因见 Log.Reason
+	StateRejected  = "rejected" // 请求未通过签名校验，原因见 Log.Reason，不可重放
+)
+
+var ErrQueueFull = errors.New("delivery queue is full")
+
+// ErrQueueClosed 服务关闭后不再接受新的投递任务
+var ErrQueueClosed = errors.New("delivery queue is closed")
+
+// RetryPolicy 重试策略：第 n 次重试前等待 BaseDelay*2^(n-1)，不超过 MaxDelay，并加入随机抖动
+type RetryPolicy struct {
+	MaxRetries int           `json:"max_retries" yaml:"max_retries"`
+	BaseDelay  time.Duration `json:"base_delay" yaml:"base_delay"`
+	MaxDelay   time.Duration `json:"max_delay" yaml:"max_delay"`
+}
+
+// backoff 返回第 attempt 次重试前的等待时间，在 [d/2, d) 之间随机取值
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + rand.N(d-half)
+}
+
+// DeadLetter 重试耗尽后仍未投递成功的事件
+type DeadLetter struct {
+	EventID    string          `json:"event_id"`
+	Request    *inboundRequest `json:"request"`
+	TargetURL  string          `json:"target_url,omitempty"`
+	Attempts   int             `json:"attempts"`
+	StatusCode int             `json:"status_code"`
+	Error      string          `json:"error,omitempty"`
+	FailedAt   time.Time       `json:"failed_at"`
+}
+
+// fail 记录失败原因
+func (a Attempt) fail(err error) Attempt {
+	a.Error = err.Error()
+	return a
+}
+
+// deliveryJob 队列中的一次投递任务
+type deliveryJob struct {
+	HookID    string
+	EventID   string
+	Request   *inboundRequest
+	TargetURL string
+	Attempt   int // 已尝试次数
+}
+
+// DeliveryQueue 异步投递队列，由固定数量的 worker 消费
+type DeliveryQueue struct {
+	store  HookStore
+	policy RetryPolicy
+	jobs   chan *deliveryJob
+	wg     sync.WaitGroup
+
+	// mu 保护 closed 与 retries，持有读锁时才向 jobs 发送，避免向已关闭的通道发送
+	mu      sync.RWMutex
+	closed  bool
+	retries map[*deliveryJob]*time.Timer // 等待重试的任务
+	abandon chan struct{}                // 关闭超时后关闭，剩余任务不再投递
+}
+
+func NewDeliveryQueue(store HookStore, workers, size int, policy RetryPolicy) *DeliveryQueue {
+	q := &DeliveryQueue{
+		store:   store,
+		policy:  policy,
+		jobs:    make(chan *deliveryJob, size),
+		retries: make(map[*deliveryJob]*time.Timer),
+		abandon: make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+	return q
+}
+
+// Enqueue 非阻塞入队，队列已满时返回 ErrQueueFull，关闭后返回 ErrQueueClosed
+func (q *DeliveryQueue) Enqueue(job *deliveryJob) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+	select {
+	case q.jobs <- job:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Len 队列中等待投递的任务数
+func (q *DeliveryQueue) Len() int {
+	return len(q.jobs)
+}
+
+// Waiting 等待重试的任务数
+func (q *DeliveryQueue) Waiting() int {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return len(q.retries)
+}
+
+func (q *DeliveryQueue) worker() {
+	defer q.wg.Done()
+	for job := range q.jobs {
+		select {
+		case <-q.abandon:
+			q.deadLetter(job, Attempt{Error: "server shut down before delivery"})
+		default:
+			q.process(job)
+		}
+	}
+}
+
+// scheduleRetry 等待 delay 后重新入队，队列已满时稍后再试。队列已关闭时返回 false，
+// 已安排的重试在关闭时由 Shutdown 转入死信
+func (q *DeliveryQueue) scheduleRetry(job *deliveryJob, delay time.Duration) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false
+	}
+	q.retries[job] = time.AfterFunc(delay, func() {
+		q.mu.Lock()
+		_, ok := q.retries[job]
+		delete(q.retries, job)
+		q.mu.Unlock()
+		if !ok {
+			return
+		}
+		err := q.Enqueue(job)
+		if errors.Is(err, ErrQueueFull) && q.scheduleRetry(job, delay) {
+			return
+		}
+		if err != nil {
+			q.deadLetter(job, Attempt{Error: "server shut down before retry"})
+		}
+	})
+	return true
+}
+
+// Shutdown 停止接收新任务，等待队列中的任务投递完成。ctx 到期后剩余任务不再投递，
+// 与等待重试的任务一起转入死信，重启后可以通过 redrive 重新投递
+func (q *DeliveryQueue) Shutdown(ctx context.Context) error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	close(q.jobs)
+	waiting := make([]*deliveryJob, 0, len(q.retries))
+	for job, t := range q.retries {
+		t.Stop()
+		waiting = append(waiting, job)
+	}
+	clear(q.retries)
+	q.mu.Unlock()
+
+	for _, job := range waiting {
+		q.deadLetter(job, Attempt{Error: "server shut down before retry"})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		// 正在进行的投递受 HTTP 客户端超时限制，只需等待它们结束
+		close(q.abandon)
+		<-done
+		return ctx.Err()
+	}
+}
+
+// EnqueueEvent 为事件的每个目标创建投递任务，入队失败的目标直接标记为 dead。
+// 返回入队后各目标的投递状态
+func (q *DeliveryQueue) EnqueueEvent(hookID string, entry Log, in *inboundRequest) []Delivery {
+	results := make([]Delivery, 0, len(entry.Deliveries))
+	for _, d := range entry.Deliveries {
+		job := &deliveryJob{HookID: hookID, EventID: entry.ID, Request: in, TargetURL: d.Target}
+		if err := q.Enqueue(job); err != nil {
+			d.State = StateDead
+			d.Error = err.Error()
+			updateDelivery(q.store, hookID, entry.ID, d.Target, func(dd *Delivery) {
+				dd.State = d.State
+				dd.Error = d.Error
+			})
+		}
+		results = append(results, d)
+	}
+	return results
+}
+
+func (q *DeliveryQueue) process(job *deliveryJob) {
+	hook, err := q.store.GetHook(job.HookID)
+	if err != nil {
+		// Hook 已删除，丢弃任务
+		return
+	}
+
+	job.Attempt++
+	ctx := utils.WithRequestID(context.Background(), job.Request.RequestID)
+	var attempt Attempt
+	var permanent bool
+	if hook.IM != nil && job.TargetURL == hook.IM.targetURL() {
+		attempt, permanent = deliverIM(ctx, hook.ID, hook.IM, job.Request)
+	} else {
+		attempt, permanent = deliver(ctx, hook, job.TargetURL, job.EventID, job.Request)
+	}
+
+	policy := q.policy
+	if hook.Retry != nil {
+		policy = *hook.Retry
+	}
+
+	state := StateDelivered
+	var next *time.Time
+	var delay time.Duration
+	if attempt.Error != "" {
+		state = StateDead
+		if !permanent && retryable(attempt.StatusCode) && job.Attempt <= policy.MaxRetries {
+			state = StateRetrying
+			delay = policy.backoff(job.Attempt)
+			at := time.Now().Add(delay)
+			next = &at
+		}
+	}
+	deliveryAttempts.inc(job.HookID, state, statusClass(attempt.StatusCode))
+	deliveryLatency.observe(attempt.Latency.Seconds(), job.HookID)
+	level := slog.LevelInfo
+	if state != StateDelivered {
+		level = slog.LevelWarn
+	}
+	attrs := []any{"hook", job.HookID, "event", job.EventID, "target", job.TargetURL,
+		"attempt", job.Attempt, "status", attempt.StatusCode, "latency", attempt.Latency}
+	if attempt.Error != "" {
+		attrs = append(attrs, "error", attempt.Error)
+	}
+	slog.Log(ctx, level, "delivery "+state, attrs...)
+
+	err = updateDelivery(q.store, job.HookID, job.EventID, job.TargetURL, func(d *Delivery) {
+		d.State = state
+		d.StatusCode = attempt.StatusCode
+		d.Error = attempt.Error
+		d.NextAttemptAt = next
+		// 编号在目标内连续递增，重新投递的尝试接在原有记录之后
+		attempt.Number = len(d.Attempts) + 1
+		d.Attempts = append(d.Attempts, attempt)
+	})
+	if err != nil && !errors.Is(err, ErrLogNotFound) && !errors.Is(err, ErrHookNotFound) {
+		slog.Error("update log", "hook", job.HookID, "event", job.EventID, "error", err)
+	}
+
+	// 记录本次尝试之后才安排重试，延迟很短时重试的结果也不会被本次的 retrying 覆盖
+	switch {
+	case state == StateRetrying && !q.scheduleRetry(job, delay):
+		q.deadLetter(job, attempt)
+	case state == StateDead:
+		q.addDeadLetter(job, attempt)
+	}
+}
+
+func (q *DeliveryQueue) addDeadLetter(job *deliveryJob, attempt Attempt) {
+	dl := DeadLetter{
+		EventID:    job.EventID,
+		Request:    job.Request,
+		TargetURL:  job.TargetURL,
+		Attempts:   job.Attempt,
+		StatusCode: attempt.StatusCode,
+		Error:      attempt.Error,
+		FailedAt:   time.Now(),
+	}
+	if err := q.store.AddDeadLetter(job.HookID, dl); err != nil {
+		slog.Error("add dead letter", "hook", job.HookID, "event", job.EventID, "error", err)
+	}
+}
+
+// deadLetter 不再投递的任务转入死信，并把投递记录标记为 dead
+func (q *DeliveryQueue) deadLetter(job *deliveryJob, reason Attempt) {
+	q.addDeadLetter(job, reason)
+	err := updateDelivery(q.store, job.HookID, job.EventID, job.TargetURL, func(d *Delivery) {
+		d.State = StateDead
+		d.Error = reason.Error
+		d.NextAttemptAt = nil
+	})
+	if err != nil && !errors.Is(err, ErrLogNotFound) && !errors.Is(err, ErrHookNotFound) {
+		slog.Error("update log", "hook", job.HookID, "event", job.EventID, "error", err)
+	}
+}
+
+// newEventLog 根据入站请求创建事件日志，每个目标对应一条待投递记录
+func newEventLog(in *inboundRequest, targets []string) Log {
+	entry := Log{
+		ID:         utils.RandomHex(16),
+		Timestamp:  time.Now(),
+		Method:     in.Method,
+		Query:      in.RawQuery,
+		Host:       in.Host,
+		Headers:    redactHeaders(in.Header),
+		RemoteAddr: in.RemoteAddr,
+		TLS:        in.TLS,
+		Body:       string(in.Body),
+		RequestID:  in.RequestID,
+	}
+	for _, t := range targets {
+		entry.Deliveries = append(entry.Deliveries, Delivery{Target: t, State: StatePending})
+	}
+	entry.summarize()
+	return entry
+}
+
+// updateDelivery 原子地修改事件在某个目标上的投递记录，记录不存在时新建，并重新汇总事件状态
+func updateDelivery(s HookStore, hookID, eventID, target string, fn func(*Delivery)) error {
+	return s.UpdateLog(hookID, eventID, func(entry *Log) {
+		i := slices.IndexFunc(entry.Deliveries, func(d Delivery) bool { return d.Target == target })
+		if i < 0 {
+			entry.Deliveries = append(entry.Deliveries, Delivery{Target: target})
+			i = len(entry.Deliveries) - 1
+		}
+		fn(&entry.Deliveries[i])
+		entry.summarize()
+	})
+}
+
+// summarize 汇总各目标状态：全部相同时取该状态，仍有待投递目标时为 pending 或 retrying，
+// 其余情况（部分成功、部分进入死信）为 partial
+func (l *Log) summarize() {
+	if len(l.Deliveries) == 0 {
+		return
+	}
+	counts := make(map[string]int)
+	for _, d := range l.Deliveries {
+		counts[d.State]++
+	}
+	switch n := len(l.Deliveries); {
+	case counts[l.Deliveries[0].State] == n:
+		l.State = l.Deliveries[0].State
+	case counts[StateRetrying] > 0:
+		l.State = StateRetrying
+	case counts[StatePending] > 0:
+		l.State = StatePending
+	default:
+		l.State = StatePartial
+	}
+
+	summary := l.Deliveries[0]
+	for _, d := range l.Deliveries {
+		if d.State != StateDelivered && d.Error != "" {
+			summary = d
+			break
+		}
+	}
+	l.StatusCode = summary.StatusCode
+	l.Error = summary.Error
+}
+
+// maxResponseBody 尝试记录中保留的响应体上限
+const maxResponseBody = 4 << 10
+
+// deliver 执行一次投递并返回尝试记录，非 2xx 响应同样视为失败。
+// 无法构造请求（地址或模板错误）时 permanent 为 true，重试没有意义
+func deliver(ctx context.Context, hook *Hook, target, eventID string, in *inboundRequest) (attempt Attempt, permanent bool) {
+	attempt = Attempt{Timestamp: time.Now(), URL: target}
+	req, err := newForwardRequest(hook, target, in)
+	if err != nil {
+		return attempt.fail(err), true
+	}
+	req = req.WithContext(ctx)
+	// 不受 AllowHeaders / DenyHeaders 限制，目标据此与本服务的日志对应
+	if in.RequestID != "" {
+		req.Header.Set("X-Request-ID", in.RequestID)
+	}
+	if hook.Signing != nil {
+		if err := hook.Signing.sign(req, eventID, attempt.Timestamp); err != nil {
+			return attempt.fail(err), true
+		}
+	}
+	attempt.URL = req.URL.String()
+
+	resp, err := forwardClient.Do(req)
+	if err != nil {
+		attempt.Latency = time.Since(attempt.Timestamp)
+		return attempt.fail(err), false
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody+1))
+	attempt.Latency = time.Since(attempt.Timestamp)
+	io.Copy(io.Discard, resp.Body)
+
+	attempt.StatusCode = resp.StatusCode
+	attempt.ResponseHeaders = resp.Header
+	if len(body) > maxResponseBody {
+		body = body[:maxResponseBody]
+		attempt.Truncated = true
+	}
+	attempt.ResponseBody = string(body)
+	if err != nil {
+		return attempt.fail(err), false
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return attempt.fail(fmt.Errorf("target responded %s", resp.Status)), false
+	}
+	return attempt, false
+}
+
+// retryable 网络错误、超时、限流和 5xx 可以重试，其余 4xx 直接进入死信
+func retryable(status int) bool {
+	switch {
+	case status == 0:
+		return true
+	case status == http.StatusRequestTimeout, status == http.StatusTooManyRequests:
+		return true
+	case status >= 500:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"webhook-proxy/utils"
+)
+
+// maxReplayEvents 单次按时间范围重放的事件上限
+const maxReplayEvents = 500
+
+// replayResult 单条事件的重放结果，EventID 为新生成的事件，失败时为空并给出 Error
+type replayResult struct {
+	Original string `json:"original"`
+	EventID  string `json:"event_id,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// replayHandler 重放已记录的事件：POST /replay/{hookID}
+//
+//	log     重放单条日志
+//	target  可选，重放到其他目标地址
+//
+// 未指定 log 时按 /logs 相同的过滤参数（since、until、status、state、q）批量重放
+func replayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "请使用 POST", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/replay/")
+	hook := authorizeHook(w, r, id)
+	if hook == nil {
+		return
+	}
+	r.ParseForm()
+
+	target := r.FormValue("target")
+	if target != "" {
+		if err := checkTargetURL(target); err != nil {
+			http.Error(w, "target 无效："+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var originals []Log
+	if logID := r.FormValue("log"); logID != "" {
+		entry, err := store.GetLog(id, logID)
+		if errors.Is(err, ErrHookNotFound) || errors.Is(err, ErrLogNotFound) {
+			http.Error(w, "日志不存在", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if entry.State == StateRejected {
+			http.Error(w, "未通过签名校验的事件不能重放", http.StatusConflict)
+			return
+		}
+		originals = []Log{entry}
+	} else {
+		q, err := parseLogQuery(r.Form)
+		if err != nil {
+			http.Error(w, "查询参数无效："+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if q.Since.IsZero() && q.Until.IsZero() {
+			http.Error(w, "请指定 log 或时间范围 since/until", http.StatusBadRequest)
+			return
+		}
+		q.Limit, q.Cursor = maxReplayEvents, ""
+		originals, _, err = store.QueryLogs(id, q)
+		if errors.Is(err, ErrHookNotFound) {
+			http.Error(w, "Webhook 不存在", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// 日志按时间倒序返回，按原始顺序重放。单条失败不影响其余事件，
+	// 全部失败时返回 503
+	results := []replayResult{}
+	failed := 0
+	for i := len(originals) - 1; i >= 0; i-- {
+		if originals[i].State == StateRejected {
+			continue
+		}
+		res := replayResult{Original: originals[i].ID}
+		eventID, err := replay(hook, originals[i], target)
+		if err != nil {
+			res.Error = err.Error()
+			failed++
+		}
+		res.EventID = eventID
+		results = append(results, res)
+	}
+
+	status := http.StatusAccepted
+	if failed > 0 && failed == len(results) {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Replayed []replayResult `json:"replayed"`
+	}{results})
+}
+
+// replay 以原始事件的请求内容生成新事件并投递到 Hook 的全部目标，指定 target 时只投递到该地址
+// 入队失败时事件已经记录为 dead，仍返回其 ID
+func replay(hook *Hook, orig Log, target string) (string, error) {
+	targets := hook.targetURLs()
+	if target != "" {
+		targets = []string{target}
+	}
+	in := orig.inbound()
+	entry := newEventLog(in, targets)
+	entry.ReplayOf = orig.ID
+	if err := store.AppendLog(hook.ID, entry); err != nil {
+		return "", err
+	}
+
+	for _, d := range queue.EnqueueEvent(hook.ID, entry, in) {
+		if d.State == StateDead {
+			return entry.ID, errors.New(d.Error)
+		}
+	}
+	return entry.ID, nil
+}
+
+// inbound 由日志还原入站请求，早期日志未记录请求方法时按 POST 处理。
+// 已脱敏的请求头不再转发
+func (l Log) inbound() *inboundRequest {
+	method := l.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	header := l.Headers.Clone()
+	for name, values := range header {
+		if slices.Contains(values, utils.Redacted) {
+			header.Del(name)
+		}
+	}
+	return &inboundRequest{
+		Method:     method,
+		RawQuery:   l.Query,
+		Header:     header,
+		Body:       []byte(l.Body),
+		RemoteAddr: l.RemoteAddr,
+		Host:       l.Host,
+		TLS:        l.TLS,
+		RequestID:  l.RequestID,
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// newServer 按配置的超时创建 HTTP 服务器，关闭时同时结束日志推送连接
+func newServer(cfg Config, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.Timeouts.ReadHeader,
+		ReadTimeout:       cfg.Timeouts.Read,
+		WriteTimeout:      cfg.Timeouts.Write,
+		IdleTimeout:       cfg.Timeouts.Idle,
+	}
+	srv.RegisterOnShutdown(logStream.close)
+	return srv
+}
+
+// run 启动服务，收到 SIGINT/SIGTERM 后依次停止接收请求、等待投递队列排空并落盘存储，
+// 全部步骤共用 cfg.Timeouts.Shutdown 的期限。再次收到信号时立即退出
+func run(cfg Config, srv *http.Server) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var reloader *certReloader
+	if cfg.TLS.CertFile != "" {
+		var err error
+		if reloader, err = newCertReloader(cfg.TLS); err != nil {
+			store.Close()
+			return err
+		}
+		srv.TLSConfig = reloader.tlsConfig()
+		go reloader.watch(ctx)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		slog.Info("listening", "addr", cfg.Listen, "tls", reloader != nil)
+		if reloader != nil {
+			errc <- srv.ListenAndServeTLS("", "")
+		} else {
+			errc <- srv.ListenAndServe()
+		}
+	}()
+	select {
+	case err := <-errc:
+		store.Close()
+		return err
+	case <-ctx.Done():
+		stop()
+	}
+
+	slog.Info("shutting down: waiting for requests and queued deliveries")
+	shutdownCtx, cancel := context.Background(), context.CancelFunc(func() {})
+	if cfg.Timeouts.Shutdown > 0 {
+		shutdownCtx, cancel = context.WithTimeout(shutdownCtx, cfg.Timeouts.Shutdown)
+	}
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("http shutdown", "error", err)
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("http server", "error", err)
+	}
+	if err := queue.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("delivery queue: unfinished deliveries moved to dead letters", "error", err)
+	}
+	if err := store.Close(); err != nil {
+		return fmt.Errorf("close store: %w", err)
+	}
+	slog.Info("shutdown complete")
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signingSecretPrefix Standard Webhooks 约定的密钥前缀
+const signingSecretPrefix = "whsec_"
+
+// defaultRotationGrace 密钥轮换后旧密钥继续签名的时长
+const defaultRotationGrace = 24 * time.Hour
+
+// Signing 按 Standard Webhooks 规范为转发请求签名，附加 webhook-id、webhook-timestamp、webhook-signature 请求头
+type Signing struct {
+	Secret string `json:"secret"` // whsec_ 前缀的 base64 密钥
+	// PreviousSecret 轮换前的密钥，在 PreviousUntil 之前与新密钥同时签名，便于接收方平滑切换
+	PreviousSecret string     `json:"previous_secret,omitempty"`
+	PreviousUntil  *time.Time `json:"previous_until,omitempty"`
+}
+
+// newSigningSecret 生成 24 字节的随机密钥
+func newSigningSecret() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return signingSecretPrefix + base64.StdEncoding.EncodeToString(b)
+}
+
+func decodeSigningSecret(secret string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, signingSecretPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing secret: %w", err)
+	}
+	return key, nil
+}
+
+// Validate 检查密钥格式
+func (s *Signing) Validate() error {
+	if s.Secret == "" {
+		return errors.New("signing secret is required")
+	}
+	if _, err := decodeSigningSecret(s.Secret); err != nil {
+		return err
+	}
+	if s.PreviousSecret != "" {
+		if _, err := decodeSigningSecret(s.PreviousSecret); err != nil {
+			return fmt.Errorf("previous secret: %w", err)
+		}
+	}
+	return nil
+}
+
+// secrets 返回当前用于签名的密钥，宽限期内包含旧密钥
+func (s *Signing) secrets(now time.Time) []string {
+	secrets := []string{s.Secret}
+	if s.PreviousSecret != "" && s.PreviousUntil != nil && now.Before(*s.PreviousUntil) {
+		secrets = append(secrets, s.PreviousSecret)
+	}
+	return secrets
+}
+
+// rotate 生成新密钥，旧密钥在 grace 内继续签名
+func (s *Signing) rotate(grace time.Duration, now time.Time) {
+	until := now.Add(grace)
+	s.PreviousSecret, s.PreviousUntil = s.Secret, &until
+	s.Secret = newSigningSecret()
+}
+
+// sign 为请求添加签名头，msgID 为事件 ID，重试时保持不变以便接收方去重
+func (s *Signing) sign(req *http.Request, msgID string, now time.Time) error {
+	var body []byte
+	if req.GetBody != nil {
+		rc, err := req.GetBody()
+		if err != nil {
+			return fmt.Errorf("read body for signing: %w", err)
+		}
+		body, err = io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("read body for signing: %w", err)
+		}
+	}
+
+	ts := strconv.FormatInt(now.Unix(), 10)
+	var sigs []string
+	for _, secret := range s.secrets(now) {
+		key, err := decodeSigningSecret(secret)
+		if err != nil {
+			return err
+		}
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(msgID + "." + ts + "."))
+		mac.Write(body)
+		sigs = append(sigs, "v1,"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	}
+
+	req.Header.Set("webhook-id", msgID)
+	req.Header.Set("webhook-timestamp", ts)
+	req.Header.Set("webhook-signature", strings.Join(sigs, " "))
+	return nil
+}
+
+// parseSigningForm 从表单读取签名配置：勾选 sign 时使用填写的密钥，未填写则自动生成
+func parseSigningForm(r *http.Request) (*Signing, error) {
+	if r.FormValue("sign") == "" {
+		return nil, nil
+	}
+	s := &Signing{Secret: strings.TrimSpace(r.FormValue("sign_secret"))}
+	if s.Secret == "" {
+		s.Secret = newSigningSecret()
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// signingHandler 轮换签名密钥：POST /signing/{hookID}/rotate，需要管理令牌，
+// 可选参数 grace 指定旧密钥继续签名的时长，默认 24h，返回新密钥
+func signingHandler(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/signing/"), "/")
+	if action != "rotate" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "请使用 POST", http.StatusMethodNotAllowed)
+		return
+	}
+	grace := defaultRotationGrace
+	if s := r.FormValue("grace"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			http.Error(w, "grace 无效", http.StatusBadRequest)
+			return
+		}
+		grace = d
+	}
+
+	hook := authorizeHook(w, r, id)
+	if hook == nil {
+		return
+	}
+	// GetHook 返回浅拷贝，修改副本以免与正在投递的请求共享
+	signing := Signing{Secret: newSigningSecret()}
+	if hook.Signing != nil {
+		signing = *hook.Signing
+		signing.rotate(grace, time.Now())
+	}
+	hook.Signing = &signing
+	if err := store.UpdateHook(hook); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hook.Signing)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Standard Webhooks 规范中的测试向量
+const (
+	specSecret    = "whsec_MfKQ9r8GKYqrTwjUPD8ILPZIo2LaLaSw"
+	specMsgID     = "msg_p5jXN8AQM9LWM0D4loKWxJek"
+	specTimestamp = 1614265330
+	specPayload   = `{"test": 2432232314}`
+	specSignature = "v1,g0hM9SsE+OTPJTGt/tmIKtSyZlE3uFJELVlNIOLJ1OE="
+)
+
+func newSigningRequest(t *testing.T) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, "https://example.com/hook", strings.NewReader(specPayload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestSigningSpecVector(t *testing.T) {
+	req := newSigningRequest(t)
+	s := &Signing{Secret: specSecret}
+	if err := s.sign(req, specMsgID, time.Unix(specTimestamp, 0)); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"webhook-id":        specMsgID,
+		"webhook-timestamp": "1614265330",
+		"webhook-signature": specSignature,
+	}
+	for name, v := range want {
+		if got := req.Header.Get(name); got != v {
+			t.Errorf("%s = %q, want %q", name, got, v)
+		}
+	}
+}
+
+func TestSigningRotation(t *testing.T) {
+	now := time.Unix(specTimestamp, 0)
+	s := &Signing{Secret: specSecret}
+	s.rotate(time.Hour, now)
+	if s.Secret == specSecret || !strings.HasPrefix(s.Secret, signingSecretPrefix) {
+		t.Fatalf("rotate() secret = %q", s.Secret)
+	}
+	if err := s.Validate(); err != nil {
+		t.Fatalf("Validate() after rotate = %v", err)
+	}
+
+	// 宽限期内新旧密钥各签一次，旧密钥的签名与规范示例一致
+	req := newSigningRequest(t)
+	if err := s.sign(req, specMsgID, now); err != nil {
+		t.Fatal(err)
+	}
+	sigs := strings.Fields(req.Header.Get("webhook-signature"))
+	if len(sigs) != 2 || sigs[1] != specSignature || !strings.HasPrefix(sigs[0], "v1,") {
+		t.Fatalf("signatures during grace = %q", sigs)
+	}
+
+	req = newSigningRequest(t)
+	if err := s.sign(req, specMsgID, now.Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if sigs := strings.Fields(req.Header.Get("webhook-signature")); len(sigs) != 1 {
+		t.Fatalf("signatures after grace = %q", sigs)
+	}
+}
+
+func TestSigningValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       Signing
+		wantErr bool
+	}{
+		{"spec secret", Signing{Secret: specSecret}, false},
+		{"generated", Signing{Secret: newSigningSecret()}, false},
+		{"empty", Signing{}, true},
+		{"not base64", Signing{Secret: "whsec_not base64!"}, true},
+		{"bad previous", Signing{Secret: specSecret, PreviousSecret: "whsec_%%"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.s.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,625 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrHookNotFound       = errors.New("hook not found")
+	ErrLogNotFound        = errors.New("log not found")
+	ErrDeadLetterNotFound = errors.New("dead letter not found")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrUserExists         = errors.New("user already exists")
+	ErrUsersExist         = errors.New("users already exist")
+)
+
+// HookStore 持久化 Webhook 及其日志
+type HookStore interface {
+	CreateHook(hook *Hook) error
+	GetHook(id string) (*Hook, error)
+	ListHooks() ([]*Hook, error)
+	UpdateHook(hook *Hook) error
+	DeleteHook(id string) error
+
+	AppendLog(hookID string, entry Log) error
+	// UpdateLog 在存储内部原子地修改一条日志，避免并发投递互相覆盖
+	UpdateLog(hookID, logID string, update func(*Log)) error
+	GetLog(hookID, logID string) (Log, error)
+	// QueryLogs 按时间倒序返回符合条件的一页日志以及下一页游标，没有更多数据时游标为空
+	QueryLogs(hookID string, q LogQuery) ([]Log, string, error)
+
+	AddDeadLetter(hookID string, dl DeadLetter) error
+	ListDeadLetters(hookID string) ([]DeadLetter, error)
+	RemoveDeadLetter(hookID, eventID string) (DeadLetter, error)
+
+	CreateUser(u *User) error
+	// CreateFirstUser 仅在尚无任何账号时创建用户，否则返回 ErrUsersExist。
+	// 检查与创建在同一把锁内完成，并发注册时只有一个请求能成为第一个管理员
+	CreateFirstUser(u *User) error
+	GetUser(name string) (*User, error)
+	ListUsers() ([]*User, error)
+	UpdateUser(u *User) error
+
+	Close() error
+}
+
+// NewHookStore 根据启动参数选择存储实现
+func NewHookStore(kind, path string, retention Retention) (HookStore, error) {
+	switch kind {
+	case "memory":
+		return newMemoryStore(retention), nil
+	case "file":
+		return newFileStore(path, retention)
+	default:
+		return nil, fmt.Errorf("unknown store type %q", kind)
+	}
+}
+
+// memoryStore 内存实现，重启后数据丢失
+type memoryStore struct {
+	mu    sync.RWMutex
+	hooks map[string]*Hook
+	logs  map[string][]Log // 新日志在前
+	dead  map[string][]DeadLetter
+	users map[string]*User
+	// retention 默认日志保留策略，可被 Hook.Retention 覆盖
+	retention Retention
+}
+
+func newMemoryStore(retention Retention) *memoryStore {
+	return &memoryStore{
+		hooks:     make(map[string]*Hook),
+		logs:      make(map[string][]Log),
+		dead:      make(map[string][]DeadLetter),
+		users:     make(map[string]*User),
+		retention: retention,
+	}
+}
+
+func (m *memoryStore) CreateHook(hook *Hook) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.hooks[hook.ID]; exists {
+		return fmt.Errorf("hook %s already exists", hook.ID)
+	}
+	h := *hook
+	m.hooks[hook.ID] = &h
+	return nil
+}
+
+func (m *memoryStore) GetHook(id string) (*Hook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	hook, exists := m.hooks[id]
+	if !exists {
+		return nil, ErrHookNotFound
+	}
+	h := *hook
+	return &h, nil
+}
+
+func (m *memoryStore) ListHooks() ([]*Hook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	list := make([]*Hook, 0, len(m.hooks))
+	for _, hook := range m.hooks {
+		h := *hook
+		list = append(list, &h)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	return list, nil
+}
+
+func (m *memoryStore) UpdateHook(hook *Hook) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.hooks[hook.ID]; !exists {
+		return ErrHookNotFound
+	}
+	h := *hook
+	m.hooks[hook.ID] = &h
+	return nil
+}
+
+func (m *memoryStore) DeleteHook(id string) error {
+	_, _, _, err := m.removeHook(id)
+	return err
+}
+
+// removeHook 删除 Hook 及其日志、死信，并返回删除的内容
+func (m *memoryStore) removeHook(id string) (*Hook, []Log, []DeadLetter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hook, exists := m.hooks[id]
+	if !exists {
+		return nil, nil, nil, ErrHookNotFound
+	}
+	logs, dead := m.logs[id], m.dead[id]
+	delete(m.hooks, id)
+	delete(m.logs, id)
+	delete(m.dead, id)
+	return hook, logs, dead, nil
+}
+
+func (m *memoryStore) AppendLog(hookID string, entry Log) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hook, exists := m.hooks[hookID]
+	if !exists {
+		return ErrHookNotFound
+	}
+	logs := append([]Log{entry}, m.logs[hookID]...)
+	m.logs[hookID] = hook.Retention.merge(m.retention).apply(logs, time.Now())
+	return nil
+}
+
+func (m *memoryStore) UpdateLog(hookID, logID string, update func(*Log)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.hooks[hookID]; !exists {
+		return ErrHookNotFound
+	}
+	logs := m.logs[hookID]
+	for i := range logs {
+		if logs[i].ID == logID {
+			// 查询方可能仍持有旧日志的副本，修改前先深拷贝
+			entry := logs[i].clone()
+			update(&entry)
+			logs[i] = entry
+			return nil
+		}
+	}
+	return ErrLogNotFound
+}
+
+func (l Log) clone() Log {
+	l.Deliveries = append([]Delivery(nil), l.Deliveries...)
+	for i := range l.Deliveries {
+		l.Deliveries[i].Attempts = append([]Attempt(nil), l.Deliveries[i].Attempts...)
+	}
+	return l
+}
+
+func (m *memoryStore) GetLog(hookID, logID string) (Log, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, exists := m.hooks[hookID]; !exists {
+		return Log{}, ErrHookNotFound
+	}
+	for _, entry := range m.logs[hookID] {
+		if entry.ID == logID {
+			return entry, nil
+		}
+	}
+	return Log{}, ErrLogNotFound
+}
+
+func (m *memoryStore) QueryLogs(hookID string, q LogQuery) ([]Log, string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	hook, exists := m.hooks[hookID]
+	if !exists {
+		return nil, "", ErrHookNotFound
+	}
+	// 过期日志在下次写入时才会真正清理，查询时先行过滤
+	logs := hook.Retention.merge(m.retention).apply(m.logs[hookID], time.Now())
+	return q.page(logs)
+}
+
+func (m *memoryStore) AddDeadLetter(hookID string, dl DeadLetter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.hooks[hookID]; !exists {
+		return ErrHookNotFound
+	}
+	m.dead[hookID] = append(m.dead[hookID], dl)
+	return nil
+}
+
+func (m *memoryStore) ListDeadLetters(hookID string) ([]DeadLetter, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, exists := m.hooks[hookID]; !exists {
+		return nil, ErrHookNotFound
+	}
+	return append([]DeadLetter{}, m.dead[hookID]...), nil
+}
+
+func (m *memoryStore) RemoveDeadLetter(hookID, eventID string) (DeadLetter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.hooks[hookID]; !exists {
+		return DeadLetter{}, ErrHookNotFound
+	}
+	list := m.dead[hookID]
+	for i, dl := range list {
+		if dl.EventID == eventID {
+			m.dead[hookID] = append(list[:i:i], list[i+1:]...)
+			return dl, nil
+		}
+	}
+	return DeadLetter{}, ErrDeadLetterNotFound
+}
+
+func (m *memoryStore) CreateUser(u *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.users[u.Name]; exists {
+		return ErrUserExists
+	}
+	m.users[u.Name] = u.clone()
+	return nil
+}
+
+func (m *memoryStore) CreateFirstUser(u *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.users) > 0 {
+		return ErrUsersExist
+	}
+	m.users[u.Name] = u.clone()
+	return nil
+}
+
+func (m *memoryStore) GetUser(name string) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	u, exists := m.users[name]
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+	return u.clone(), nil
+}
+
+func (m *memoryStore) ListUsers() ([]*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	list := make([]*User, 0, len(m.users))
+	for _, u := range m.users {
+		list = append(list, u.clone())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+func (m *memoryStore) UpdateUser(u *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.users[u.Name]; !exists {
+		return ErrUserNotFound
+	}
+	m.users[u.Name] = u.clone()
+	return nil
+}
+
+func (m *memoryStore) Close() error {
+	return nil
+}
+
+// restoreHook 撤销对 Hook 的修改，hook 为 nil 时删除
+func (m *memoryStore) restoreHook(id string, hook *Hook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if hook == nil {
+		delete(m.hooks, id)
+		return
+	}
+	m.hooks[id] = hook
+}
+
+// restoreDeleted 恢复 removeHook 删除的内容
+func (m *memoryStore) restoreDeleted(hook *Hook, logs []Log, dead []DeadLetter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks[hook.ID] = hook
+	if logs != nil {
+		m.logs[hook.ID] = logs
+	}
+	if dead != nil {
+		m.dead[hook.ID] = dead
+	}
+}
+
+// restoreUser 恢复用户，u 为 nil 时删除
+func (m *memoryStore) restoreUser(name string, u *User) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u == nil {
+		delete(m.users, name)
+		return
+	}
+	m.users[name] = u
+}
+
+// userState、hookState 返回修改前的原值，写入失败时用于撤销
+func (m *memoryStore) userState(name string) *User {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.users[name]
+}
+
+func (m *memoryStore) hookState(id string) *Hook {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.hooks[id]
+}
+
+// logFlushInterval 日志与死信批量落盘的间隔，进程异常退出时最多丢失这段时间内的记录
+const logFlushInterval = time.Second
+
+// fileStore 基于本地文件的持久化实现：数据常驻内存，写入后整体落盘。
+// Hook 与用户的修改立即落盘，写入失败时撤销内存中的修改；日志与死信变化频繁，
+// 只标记为待写入，由后台每 logFlushInterval 合并落盘一次
+type fileStore struct {
+	*memoryStore
+	path  string
+	wmu   sync.Mutex  // 保证写操作与落盘顺序一致
+	dirty atomic.Bool // 有尚未落盘的日志或死信
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// fileSnapshot 落盘文件格式
+type fileSnapshot struct {
+	Hooks       []*Hook                 `json:"hooks"`
+	Logs        map[string][]Log        `json:"logs"`
+	DeadLetters map[string][]DeadLetter `json:"dead_letters"`
+	Users       []*User                 `json:"users,omitempty"`
+}
+
+func newFileStore(path string, retention Retention) (*fileStore, error) {
+	f := &fileStore{
+		memoryStore: newMemoryStore(retention),
+		path:        path,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	if err := f.load(); err != nil {
+		return nil, fmt.Errorf("load %s: %w", path, err)
+	}
+	go f.flushLoop()
+	return f, nil
+}
+
+// flushLoop 定期写入待落盘的日志与死信，失败时保留标记，下次重试
+func (f *fileStore) flushLoop() {
+	defer close(f.done)
+	ticker := time.NewTicker(logFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+			if err := f.flush(); err != nil {
+				slog.Error("flush store", "path", f.path, "error", err)
+			}
+		}
+	}
+}
+
+func (f *fileStore) flush() error {
+	if !f.dirty.Swap(false) {
+		return nil
+	}
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+	if err := f.persist(); err != nil {
+		f.dirty.Store(true)
+		return err
+	}
+	return nil
+}
+
+func (f *fileStore) load() error {
+	b, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snap fileSnapshot
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return err
+	}
+	for _, hook := range snap.Hooks {
+		if hook.TargetURL != "" && len(hook.Targets) == 0 {
+			hook.Targets = []Target{{URL: hook.TargetURL}}
+		}
+		hook.TargetURL = ""
+		f.hooks[hook.ID] = hook
+	}
+	for _, u := range snap.Users {
+		f.users[u.Name] = u
+	}
+	for id, logs := range snap.Logs {
+		if _, exists := f.hooks[id]; exists {
+			f.logs[id] = logs
+		}
+	}
+	for id, list := range snap.DeadLetters {
+		if _, exists := f.hooks[id]; exists {
+			f.dead[id] = list
+		}
+	}
+	return nil
+}
+
+// persist 先写临时文件再原子替换，避免进程中断导致文件损坏
+func (f *fileStore) persist() error {
+	f.mu.RLock()
+	snap := fileSnapshot{
+		Hooks:       make([]*Hook, 0, len(f.hooks)),
+		Logs:        f.logs,
+		DeadLetters: f.dead,
+	}
+	for _, hook := range f.hooks {
+		snap.Hooks = append(snap.Hooks, hook)
+	}
+	for _, u := range f.users {
+		snap.Users = append(snap.Users, u)
+	}
+	b, err := json.Marshal(snap)
+	f.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+func (f *fileStore) CreateHook(hook *Hook) error {
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+	if err := f.memoryStore.CreateHook(hook); err != nil {
+		return err
+	}
+	if err := f.persist(); err != nil {
+		f.restoreHook(hook.ID, nil)
+		return err
+	}
+	return nil
+}
+
+func (f *fileStore) UpdateHook(hook *Hook) error {
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+	old := f.hookState(hook.ID)
+	if err := f.memoryStore.UpdateHook(hook); err != nil {
+		return err
+	}
+	if err := f.persist(); err != nil {
+		f.restoreHook(hook.ID, old)
+		return err
+	}
+	return nil
+}
+
+func (f *fileStore) DeleteHook(id string) error {
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+	old, logs, dead, err := f.removeHook(id)
+	if err != nil {
+		return err
+	}
+	if err := f.persist(); err != nil {
+		f.restoreDeleted(old, logs, dead)
+		return err
+	}
+	return nil
+}
+
+func (f *fileStore) AppendLog(hookID string, entry Log) error {
+	if err := f.memoryStore.AppendLog(hookID, entry); err != nil {
+		return err
+	}
+	f.dirty.Store(true)
+	return nil
+}
+
+func (f *fileStore) UpdateLog(hookID, logID string, update func(*Log)) error {
+	if err := f.memoryStore.UpdateLog(hookID, logID, update); err != nil {
+		return err
+	}
+	f.dirty.Store(true)
+	return nil
+}
+
+func (f *fileStore) AddDeadLetter(hookID string, dl DeadLetter) error {
+	if err := f.memoryStore.AddDeadLetter(hookID, dl); err != nil {
+		return err
+	}
+	f.dirty.Store(true)
+	return nil
+}
+
+func (f *fileStore) RemoveDeadLetter(hookID, eventID string) (DeadLetter, error) {
+	dl, err := f.memoryStore.RemoveDeadLetter(hookID, eventID)
+	if err != nil {
+		return dl, err
+	}
+	f.dirty.Store(true)
+	return dl, nil
+}
+
+func (f *fileStore) CreateUser(u *User) error {
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+	if err := f.memoryStore.CreateUser(u); err != nil {
+		return err
+	}
+	if err := f.persist(); err != nil {
+		f.restoreUser(u.Name, nil)
+		return err
+	}
+	return nil
+}
+
+func (f *fileStore) CreateFirstUser(u *User) error {
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+	if err := f.memoryStore.CreateFirstUser(u); err != nil {
+		return err
+	}
+	if err := f.persist(); err != nil {
+		f.restoreUser(u.Name, nil)
+		return err
+	}
+	return nil
+}
+
+func (f *fileStore) UpdateUser(u *User) error {
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+	old := f.userState(u.Name)
+	if err := f.memoryStore.UpdateUser(u); err != nil {
+		return err
+	}
+	if err := f.persist(); err != nil {
+		f.restoreUser(u.Name, old)
+		return err
+	}
+	return nil
+}
+
+// Close 停止后台写入并把全部数据落盘，只能调用一次
+func (f *fileStore) Close() error {
+	close(f.stop)
+	<-f.done
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+	return f.persist()
+}
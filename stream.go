@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 推送给订阅者的事件类型
+const (
+	streamNewLog    = "log"    // 新事件
+	streamLogUpdate = "update" // 事件的投递状态或尝试记录发生变化
+)
+
+// subscriberBuffer 每个订阅者缓存的事件数，写满后丢弃新事件，不阻塞写日志的一方
+const subscriberBuffer = 64
+
+type streamEvent struct {
+	Type string
+	Log  Log
+}
+
+type subscriber struct {
+	ch      chan streamEvent
+	dropped atomic.Int64 // 因缓冲区已满丢弃的事件数
+}
+
+// logBroker 按 Hook 分发日志变化，支持多个并发订阅者
+type logBroker struct {
+	mu     sync.RWMutex
+	subs   map[string]map[*subscriber]struct{}
+	closed bool
+}
+
+func newLogBroker() *logBroker {
+	return &logBroker{subs: make(map[string]map[*subscriber]struct{})}
+}
+
+func (b *logBroker) subscribe(hookID string) *subscriber {
+	s := &subscriber{ch: make(chan streamEvent, subscriberBuffer)}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(s.ch)
+		return s
+	}
+	if b.subs[hookID] == nil {
+		b.subs[hookID] = make(map[*subscriber]struct{})
+	}
+	b.subs[hookID][s] = struct{}{}
+	return s
+}
+
+func (b *logBroker) unsubscribe(hookID string, s *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subs[hookID], s)
+	if len(b.subs[hookID]) == 0 {
+		delete(b.subs, hookID)
+	}
+}
+
+// watched 是否有订阅者，没有时跳过读取最新日志
+func (b *logBroker) watched(hookID string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subs[hookID]) > 0
+}
+
+// close 结束全部订阅，服务关闭时让 SSE 连接退出
+func (b *logBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for _, subs := range b.subs {
+		for s := range subs {
+			close(s.ch)
+		}
+	}
+	clear(b.subs)
+}
+
+// publish 非阻塞地发送给全部订阅者
+func (b *logBroker) publish(hookID string, ev streamEvent) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subs[hookID] {
+		select {
+		case s.ch <- ev:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// publishingStore 在日志写入成功后通知 logBroker
+type publishingStore struct {
+	HookStore
+	broker *logBroker
+}
+
+func (s *publishingStore) AppendLog(hookID string, entry Log) error {
+	if err := s.HookStore.AppendLog(hookID, entry); err != nil {
+		return err
+	}
+	if s.broker.watched(hookID) {
+		s.broker.publish(hookID, streamEvent{Type: streamNewLog, Log: entry.clone()})
+	}
+	return nil
+}
+
+func (s *publishingStore) UpdateLog(hookID, logID string, update func(*Log)) error {
+	if err := s.HookStore.UpdateLog(hookID, logID, update); err != nil {
+		return err
+	}
+	if s.broker.watched(hookID) {
+		if entry, err := s.HookStore.GetLog(hookID, logID); err == nil {
+			s.broker.publish(hookID, streamEvent{Type: streamLogUpdate, Log: entry})
+		}
+	}
+	return nil
+}
+
+var logStream = newLogBroker()
+
+// streamHeartbeat 定期发送注释行，避免代理因空闲断开连接
+const streamHeartbeat = 15 * time.Second
+
+// streamLogs 以 Server-Sent Events 推送 Hook 的新日志与投递更新：GET /logs/{hookID}/stream。
+// 事件名为 log 或 update，data 为完整的日志 JSON；订阅者处理过慢时丢弃的事件数通过 dropped 事件告知。
+// 每次推送和心跳前重新检查访问权限，Hook 被删除、转交其他团队或凭据失效后关闭连接
+func streamLogs(w http.ResponseWriter, r *http.Request, hookID string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "不支持流式响应", http.StatusInternalServerError)
+		return
+	}
+	// 长连接不受服务器写超时限制
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	sub := logStream.subscribe(hookID)
+	defer logStream.unsubscribe(hookID, sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if !streamAllowed(r, hookID) {
+				return
+			}
+			fmt.Fprint(w, ": ping\n\n")
+		case ev, ok := <-sub.ch:
+			if !ok || !streamAllowed(r, hookID) {
+				return
+			}
+			if n := sub.dropped.Swap(0); n > 0 {
+				fmt.Fprintf(w, "event: dropped\ndata: {\"count\":%d}\n\n", n)
+			}
+			b, err := json.Marshal(ev.Log)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\nid: %s\ndata: %s\n\n", ev.Type, ev.Log.ID, b)
+		}
+		flusher.Flush()
+	}
+}
+
+// streamAllowed 按建立连接时的凭据重新检查对 Hook 的访问权限
+func streamAllowed(r *http.Request, hookID string) bool {
+	_, status, _ := hookAccess(r, hookID)
+	return status == http.StatusOK
+}
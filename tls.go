@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// certReloader 持有当前生效的 TLS 配置，证书文件变化或收到 SIGHUP 时重新加载。
+// 新配置只影响之后的握手，已建立的连接不受影响；加载失败时继续使用原有证书
+type certReloader struct {
+	cfg     TLSConfig
+	current atomic.Pointer[tls.Config]
+	stamp   string // 证书、私钥与 CA 文件的修改时间和大小，用于检测变化
+}
+
+func newCertReloader(cfg TLSConfig) (*certReloader, error) {
+	r := &certReloader{cfg: cfg}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.cfg.CertFile, r.cfg.KeyFile}
+	if r.cfg.ClientCAFile != "" {
+		files = append(files, r.cfg.ClientCAFile)
+	}
+	return files
+}
+
+// fileStamp 文件不存在时返回错误，轮换证书的过程中可能短暂出现
+func (r *certReloader) fileStamp() (string, error) {
+	var b strings.Builder
+	for _, f := range r.files() {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s:%d:%d;", f, fi.ModTime().UnixNano(), fi.Size())
+	}
+	return b.String(), nil
+}
+
+func (r *certReloader) reload() error {
+	stamp, err := r.fileStamp()
+	if err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+	// 加载失败时同样记下，文件再次变化前不重复尝试
+	r.stamp = stamp
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+	c := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if r.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("tls: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("tls: no certificates found in %s", r.cfg.ClientCAFile)
+		}
+		// 只有部分 Hook 要求客户端证书，握手时不强制，由 hookHandler 按 Hook 检查
+		c.ClientCAs = pool
+		c.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	r.current.Store(c)
+	return nil
+}
+
+// tlsConfig 返回交给 http.Server 的配置，每次握手取当前证书
+func (r *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current.Load(), nil
+		},
+	}
+}
+
+// watch 收到 SIGHUP 时重新加载，并按 ReloadInterval 检查文件是否变化，直到 ctx 结束
+func (r *certReloader) watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	var tick <-chan time.Time
+	if r.cfg.ReloadInterval > 0 {
+		t := time.NewTicker(r.cfg.ReloadInterval)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		case <-tick:
+			if stamp, err := r.fileStamp(); err != nil || stamp == r.stamp {
+				continue
+			}
+		}
+		if err := r.reload(); err != nil {
+			slog.Error("tls: reload certificate, keeping the current one", "error", err)
+			continue
+		}
+		slog.Info("tls: certificate reloaded", "cert_file", r.cfg.CertFile)
+	}
+}
+
+// clientCAConfigured 服务是否以 TLS 运行并配置了 client_ca_file，由 loadConfig 设置。
+// 未配置时无法校验客户端证书，Hook 不能设置 client_cert
+var clientCAConfigured bool
+
+// ClientCertPolicy 要求调用方出示由 tls.client_ca_file 签发的客户端证书（mTLS）
+type ClientCertPolicy struct {
+	Required bool     `json:"required"`        // API 中传入 false 表示取消要求
+	Names    []string `json:"names,omitempty"` // 允许的证书 CN 或 DNS 名称，为空时接受任意有效证书
+}
+
+// check 检查连接上已通过 CA 校验的客户端证书
+func (p *ClientCertPolicy) check(state *tls.ConnectionState) error {
+	if state == nil {
+		return errors.New("client certificate required: not a TLS connection")
+	}
+	if len(state.VerifiedChains) == 0 {
+		return errors.New("client certificate required")
+	}
+	if len(p.Names) == 0 {
+		return nil
+	}
+	leaf := state.VerifiedChains[0][0]
+	if slices.Contains(p.Names, leaf.Subject.CommonName) {
+		return nil
+	}
+	for _, name := range leaf.DNSNames {
+		if slices.Contains(p.Names, name) {
+			return nil
+		}
+	}
+	return fmt.Errorf("client certificate %q not allowed", leaf.Subject.CommonName)
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Transform 转发前对请求进行改写的模板，字段为空时保持原样
+type Transform struct {
+	Body    string            `json:"body,omitempty"`    // 请求体模板
+	Headers map[string]string `json:"headers,omitempty"` // 请求头模板，渲染结果为空时删除该请求头
+	Path    string            `json:"path,omitempty"`    // 目标地址路径模板，渲染结果替换目标地址的路径
+}
+
+// transformData 模板上下文
+type transformData struct {
+	Body    interface{} // 请求体按 JSON 解析的结果，非 JSON 时为 nil
+	Raw     string      // 原始请求体
+	Headers http.Header
+	Query   url.Values
+	Method  string
+}
+
+var transformFuncs = template.FuncMap{
+	"jsonpath": jsonPath,
+	"default":  defaultValue,
+	"date":     formatDate,
+	"toJSON":   toJSON,
+	"now":      time.Now,
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+	"trim":     strings.TrimSpace,
+	"join":     joinValues,
+}
+
+// maxCachedTemplates 缓存的模板数上限。模板修改后旧文本不会再被使用，
+// 达到上限时清空缓存，由仍在使用的模板重新填充
+const maxCachedTemplates = 1024
+
+var (
+	templateMu    sync.Mutex
+	templateCache = make(map[string]*template.Template) // 已解析的模板，键为模板文本
+)
+
+func parseTemplate(text string) (*template.Template, error) {
+	templateMu.Lock()
+	t, ok := templateCache[text]
+	templateMu.Unlock()
+	if ok {
+		return t, nil
+	}
+	t, err := template.New("transform").Funcs(transformFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	templateMu.Lock()
+	if len(templateCache) >= maxCachedTemplates {
+		clear(templateCache)
+	}
+	templateCache[text] = t
+	templateMu.Unlock()
+	return t, nil
+}
+
+func renderTemplate(text string, data interface{}) (string, error) {
+	t, err := parseTemplate(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Validate 检查全部模板能否解析
+func (t *Transform) Validate() error {
+	if _, err := parseTemplate(t.Body); err != nil {
+		return fmt.Errorf("body template: %w", err)
+	}
+	if _, err := parseTemplate(t.Path); err != nil {
+		return fmt.Errorf("path template: %w", err)
+	}
+	for name, text := range t.Headers {
+		if _, err := parseTemplate(text); err != nil {
+			return fmt.Errorf("header %s template: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func newTransformData(in *inboundRequest) transformData {
+	data := transformData{
+		Raw:     string(in.Body),
+		Headers: in.Header,
+		Method:  in.Method,
+	}
+	data.Query, _ = url.ParseQuery(in.RawQuery)
+	// 使用 json.Number 保留整数精度，避免大整数被渲染成科学计数法
+	dec := json.NewDecoder(bytes.NewReader(in.Body))
+	dec.UseNumber()
+	if err := dec.Decode(&data.Body); err != nil {
+		data.Body = nil
+	}
+	return data
+}
+
+// apply 改写已构造好的转发请求
+func (t *Transform) apply(req *http.Request, in *inboundRequest) error {
+	data := newTransformData(in)
+
+	if t.Body != "" {
+		body, err := renderTemplate(t.Body, data)
+		if err != nil {
+			return fmt.Errorf("render body: %w", err)
+		}
+		b := []byte(body)
+		req.Body = io.NopCloser(bytes.NewReader(b))
+		req.ContentLength = int64(len(b))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(b)), nil
+		}
+	}
+
+	for name, text := range t.Headers {
+		v, err := renderTemplate(text, data)
+		if err != nil {
+			return fmt.Errorf("render header %s: %w", name, err)
+		}
+		if v = strings.TrimSpace(v); v == "" {
+			req.Header.Del(name)
+		} else {
+			req.Header.Set(name, v)
+		}
+	}
+
+	if t.Path != "" {
+		p, err := renderTemplate(t.Path, data)
+		if err != nil {
+			return fmt.Errorf("render path: %w", err)
+		}
+		if !strings.HasPrefix(p, "/") {
+			p = "/" + p
+		}
+		req.URL.Path, req.URL.RawPath = p, ""
+	}
+	return nil
+}
+
+// jsonPath 按 "a.b[0].c" 或 "a.b.0.c" 形式的路径取值，路径不存在时返回 nil
+func jsonPath(path string, v interface{}) interface{} {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	path = strings.NewReplacer("[", ".", "]", "").Replace(path)
+	if path == "" {
+		return v
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil
+			}
+			v = node[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+// defaultValue 用法：default "无" .x，值为空时返回默认值
+func defaultValue(def, v interface{}) interface{} {
+	if v == nil {
+		return def
+	}
+	rv := reflect.ValueOf(v)
+	if rv.IsZero() {
+		return def
+	}
+	switch rv.Kind() {
+	case reflect.Map, reflect.Slice, reflect.String:
+		if rv.Len() == 0 {
+			return def
+		}
+	}
+	return v
+}
+
+// formatDate 用法：date "2006-01-02 15:04" .ts，支持 RFC3339 字符串、Unix 秒（数值或字符串）和 time.Time
+func formatDate(layout string, v interface{}) (string, error) {
+	var t time.Time
+	switch x := v.(type) {
+	case time.Time:
+		t = x
+	case json.Number:
+		n, err := x.Int64()
+		if err != nil {
+			return "", fmt.Errorf("date: cannot parse %q", x)
+		}
+		t = time.Unix(n, 0)
+	case float64:
+		t = time.Unix(int64(x), 0)
+	case int64:
+		t = time.Unix(x, 0)
+	case int:
+		t = time.Unix(int64(x), 0)
+	case string:
+		if n, err := strconv.ParseInt(x, 10, 64); err == nil {
+			t = time.Unix(n, 0)
+			break
+		}
+		parsed, err := time.Parse(time.RFC3339, x)
+		if err != nil {
+			return "", fmt.Errorf("date: cannot parse %q", x)
+		}
+		t = parsed
+	case nil:
+		return "", nil
+	default:
+		return "", fmt.Errorf("date: unsupported value %T", v)
+	}
+	return t.Format(layout), nil
+}
+
+func toJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// joinValues 用法：join ", " .list
+func joinValues(sep string, v interface{}) string {
+	list, ok := v.([]interface{})
+	if !ok {
+		return fmt.Sprint(v)
+	}
+	parts := make([]string, 0, len(list))
+	for _, item := range list {
+		parts = append(parts, fmt.Sprint(item))
+	}
+	return strings.Join(parts, sep)
+}
+
+// previewHandler 预览改写结果：POST /preview/{hookID}，请求体和请求头作为样例事件，
+// 返回每个目标将收到的请求，不会真正投递
+func previewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "请使用 POST", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/preview/")
+	hook := authorizeHook(w, r, id)
+	if hook == nil {
+		return
+	}
+
+	defer r.Body.Close()
+	maxBody := limiter.limits(hook).MaxBodyBytes
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("请求体超过 %d 字节", maxBody), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "读取请求体失败："+err.Error(), http.StatusBadRequest)
+		return
+	}
+	in := captureInbound(r, body)
+	// 凭据用于访问预览接口，不属于样例事件
+	in.Header.Del("Authorization")
+	in.Header.Del("Cookie")
+
+	type preview struct {
+		Method  string      `json:"method"`
+		URL     string      `json:"url"`
+		Headers http.Header `json:"headers,omitempty"`
+		Body    string      `json:"body,omitempty"`
+		Error   string      `json:"error,omitempty"`
+	}
+	previews := []preview{}
+	for _, target := range hook.targetURLs() {
+		if hook.IM != nil && target == hook.IM.targetURL() {
+			p := preview{Method: "SendMessage", URL: target}
+			msg, err := hook.IM.buildMessage(in)
+			if err != nil {
+				p.Error = err.Error()
+			} else {
+				b, _ := json.MarshalIndent(msg, "", "  ")
+				p.Body = string(b)
+			}
+			previews = append(previews, p)
+			continue
+		}
+		req, err := newForwardRequest(hook, target, in)
+		if err == nil && hook.Signing != nil {
+			err = hook.Signing.sign(req, "preview", time.Now())
+		}
+		if err != nil {
+			previews = append(previews, preview{URL: target, Error: err.Error()})
+			continue
+		}
+		out, _ := io.ReadAll(req.Body)
+		previews = append(previews, preview{
+			Method:  req.Method,
+			URL:     req.URL.String(),
+			Headers: req.Header,
+			Body:    string(out),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(previews)
+}
+
+// parseTransformForm 从表单读取转换模板，未填写时返回 nil
+func parseTransformForm(r *http.Request) (*Transform, error) {
+	body, path, headers := r.FormValue("transform_body"), r.FormValue("transform_path"), r.FormValue("transform_headers")
+	if body == "" && path == "" && headers == "" {
+		return nil, nil
+	}
+	t := &Transform{Body: body, Path: strings.TrimSpace(path)}
+	var err error
+	if t.Headers, err = parseHeaderTemplates(headers); err != nil {
+		return nil, err
+	}
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// parseHeaderTemplates 解析表单中每行一个的 "Name: 模板" 配置
+func parseHeaderTemplates(s string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		name, text, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid header template %q", line)
+		}
+		headers[http.CanonicalHeaderKey(strings.TrimSpace(name))] = strings.TrimSpace(text)
+	}
+	return headers, nil
+}
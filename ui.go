@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+// pages 启动时解析一次全部页面模板，按文件名执行
+var pages = template.Must(template.New("").Funcs(template.FuncMap{
+	"prettyJSON": prettyJSON,
+	"toJSON":     toJSON,
+	"timeFmt":    func(t time.Time) string { return t.Local().Format("2006-01-02 15:04:05") },
+	"latency":    func(d time.Duration) string { return d.Round(time.Millisecond).String() },
+	"stateClass": stateClass,
+	"list":       func(v ...string) []string { return v },
+}).ParseFS(templateFS, "templates/*.html"))
+
+// renderPage 执行页面模板，先写入缓冲区，出错时不会输出半个页面
+func renderPage(w http.ResponseWriter, status int, name string, data interface{}) {
+	var buf bytes.Buffer
+	if err := pages.ExecuteTemplate(&buf, name, data); err != nil {
+		slog.Error("render page", "template", name, "error", err)
+		http.Error(w, "页面渲染失败", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+// prettyJSON 缩进 JSON 文本，非 JSON 原样返回
+func prettyJSON(s string) string {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte(s), "", "  "); err != nil {
+		return s
+	}
+	return buf.String()
+}
+
+func stateClass(state string) string {
+	switch state {
+	case StateDelivered:
+		return "ok"
+	case StateDead, StateRejected:
+		return "bad"
+	case StatePartial, StateRetrying:
+		return "warn"
+	}
+	return "muted"
+}
+
+// recentLogs 统计成功率时取样的最近事件数
+const recentLogs = 50
+
+// hookSummary 控制台中的一行
+type hookSummary struct {
+	*Hook
+	Delivered int // 最近事件中成功与失败的投递次数，进行中的不计入
+	Failed    int
+	LastEvent time.Time
+}
+
+func (s hookSummary) SuccessRate() string {
+	if s.Delivered+s.Failed == 0 {
+		return "—"
+	}
+	return fmt.Sprintf("%.1f%%", float64(s.Delivered)*100/float64(s.Delivered+s.Failed))
+}
+
+func summarizeHook(h *Hook) hookSummary {
+	s := hookSummary{Hook: h}
+	logs, _, err := store.QueryLogs(h.ID, LogQuery{Limit: recentLogs})
+	if err != nil {
+		return s
+	}
+	if len(logs) > 0 {
+		s.LastEvent = logs[0].Timestamp
+	}
+	for _, l := range logs {
+		for _, d := range l.Deliveries {
+			switch d.State {
+			case StateDelivered:
+				s.Delivered++
+			case StateDead:
+				s.Failed++
+			}
+		}
+	}
+	return s
+}
+
+// dashboardHandler 控制台首页：列出当前用户可以访问的 Hook
+func dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	user := currentUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	hooks, err := store.ListHooks()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var rows []hookSummary
+	for _, h := range hooks {
+		if user.canAccess(h) {
+			rows = append(rows, summarizeHook(h))
+		}
+	}
+	renderPage(w, http.StatusOK, "dashboard.html", struct {
+		User   *User
+		Hooks  []hookSummary
+		Recent int
+	}{user, rows, recentLogs})
+}
+
+// newHookPageHandler 创建 Webhook 的表单
+func newHookPageHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	renderPage(w, http.StatusOK, "create.html", struct{ User *User }{user})
+}
+
+// hookPageHandler Hook 详情与日志页面：
+//
+//	GET /dashboard/{hookID}          配置与日志列表，支持 /logs 相同的过滤参数
+//	GET /dashboard/{hookID}/{logID}  单条日志：请求头、请求体与每次投递的响应
+func hookPageHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	id, logID, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/dashboard/"), "/")
+	hook := authorizeHook(w, r, id)
+	if hook == nil {
+		return
+	}
+
+	if logID != "" {
+		entry, err := store.GetLog(id, logID)
+		if errors.Is(err, ErrLogNotFound) {
+			http.Error(w, "日志不存在", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		renderPage(w, http.StatusOK, "log.html", struct {
+			User *User
+			Hook *Hook
+			Log  Log
+		}{user, hook, entry})
+		return
+	}
+
+	q, err := parseLogQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, "查询参数无效："+err.Error(), http.StatusBadRequest)
+		return
+	}
+	logs, next, err := store.QueryLogs(id, q)
+	if errors.Is(err, ErrInvalidCursor) {
+		http.Error(w, "cursor 无效", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	nextQuery := r.URL.Query()
+	nextQuery.Set("cursor", next)
+	renderPage(w, http.StatusOK, "hook.html", struct {
+		User      *User
+		Hook      hookSummary
+		Limits    Limits // 合并全局默认值后生效的限制
+		Logs      []Log
+		State     string
+		NextQuery string
+		HasNext   bool
+	}{user, summarizeHook(hook), limiter.limits(hook), logs, q.State, nextQuery.Encode(), next != ""})
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"webhook-proxy/utils"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// User 账号。管理员可以访问全部 Hook，其他用户只能访问自己或所在团队的 Hook
+type User struct {
+	Name         string    `json:"name"`
+	PasswordHash string    `json:"password_hash"` // bcrypt
+	Admin        bool      `json:"admin,omitempty"`
+	Teams        []string  `json:"teams,omitempty"`
+	APIKeys      []APIKey  `json:"api_keys,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// APIKey 供脚本调用 JSON API，只保存 SHA-256，明文仅在创建时返回一次
+type APIKey struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// apiKeyPrefix API Key 格式为 whk_{id}.{secret}，据此与 Hook 管理令牌区分
+const apiKeyPrefix = "whk_"
+
+const minPasswordLength = 8
+
+func (u *User) clone() *User {
+	c := *u
+	c.Teams = slices.Clone(u.Teams)
+	c.APIKeys = slices.Clone(u.APIKeys)
+	return &c
+}
+
+func (u *User) setPassword(password string) error {
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	u.PasswordHash = string(hash)
+	return nil
+}
+
+func (u *User) checkPassword(password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil
+}
+
+// newAPIKey 为用户生成 API Key，返回明文
+func (u *User) newAPIKey(name string) (APIKey, string) {
+	id, secret := utils.RandomHex(8), utils.RandomHex(24)
+	plain := apiKeyPrefix + id + "." + secret
+	key := APIKey{ID: id, Name: name, Hash: hashToken(plain), CreatedAt: time.Now()}
+	u.APIKeys = append(u.APIKeys, key)
+	return key, plain
+}
+
+// newUser 校验用户名并设置密码
+func newUser(name, password string, admin bool, teams []string) (*User, error) {
+	if name == "" || strings.ContainsAny(name, " \t\n/") {
+		return nil, fmt.Errorf("invalid user name %q", name)
+	}
+	u := &User{Name: name, Admin: admin, Teams: teams, CreatedAt: time.Now()}
+	if err := u.setPassword(password); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// canAccess 所有者、Hook 所属团队的成员以及管理员可以查看和修改 Hook
+func (u *User) canAccess(h *Hook) bool {
+	if u.Admin {
+		return true
+	}
+	if h.Owner != "" && h.Owner == u.Name {
+		return true
+	}
+	return h.Team != "" && slices.Contains(u.Teams, h.Team)
+}
+
+// userByAPIKey 按 API Key 查找用户
+func userByAPIKey(plain string) (*User, error) {
+	id, _, ok := strings.Cut(strings.TrimPrefix(plain, apiKeyPrefix), ".")
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	users, err := store.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+	hash := hashToken(plain)
+	for _, u := range users {
+		for _, k := range u.APIKeys {
+			if k.ID == id && subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hash)) == 1 {
+				return u, nil
+			}
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+// noUsers 尚未创建任何账号时允许注册第一个管理员
+func noUsers() bool {
+	users, err := store.ListUsers()
+	return err == nil && len(users) == 0
+}
+
+// createUser 保存新账号。bootstrap 为 true 时由存储确认仍没有任何账号，
+// noUsers 的结果可能已被并发的注册请求改变
+func createUser(u *User, bootstrap bool) error {
+	if bootstrap {
+		return store.CreateFirstUser(u)
+	}
+	return store.CreateUser(u)
+}
+
+// sessionCookie 登录会话的 Cookie 名称，会话只保存在内存中，重启后需重新登录
+const (
+	sessionCookie = "session"
+	sessionTTL    = 24 * time.Hour
+)
+
+type session struct {
+	user    string
+	expires time.Time
+}
+
+var sessions = struct {
+	sync.Mutex
+	m map[string]session
+}{m: make(map[string]session)}
+
+func startSession(w http.ResponseWriter, r *http.Request, name string) {
+	token := utils.RandomHex(32)
+	expires := time.Now().Add(sessionTTL)
+	sessions.Lock()
+	for t, s := range sessions.m {
+		if time.Now().After(s.expires) {
+			delete(sessions.m, t)
+		}
+	}
+	sessions.m[token] = session{user: name, expires: expires}
+	sessions.Unlock()
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		// Lax 阻止跨站表单 POST 携带 Cookie
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func endSession(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		sessions.Lock()
+		delete(sessions.m, c.Value)
+		sessions.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+}
+
+// currentUser 依次通过 API Key 和登录会话识别用户，未登录时返回 nil
+func currentUser(r *http.Request) *User {
+	if v, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && strings.HasPrefix(v, apiKeyPrefix) {
+		u, err := userByAPIKey(strings.TrimSpace(v))
+		if err != nil {
+			return nil
+		}
+		return u
+	}
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	sessions.Lock()
+	s, ok := sessions.m[c.Value]
+	sessions.Unlock()
+	if !ok || time.Now().After(s.expires) {
+		return nil
+	}
+	u, err := store.GetUser(s.user)
+	if err != nil {
+		return nil
+	}
+	return u
+}
+
+var errForbidden = errors.New("forbidden")
+
+// hookAccess 读取 Hook 并检查当前请求能否管理它：有权限的用户或持有该 Hook 的管理令牌。
+// 失败时返回对应的 HTTP 状态码
+func hookAccess(r *http.Request, id string) (*Hook, int, error) {
+	hook, err := store.GetHook(id)
+	if errors.Is(err, ErrHookNotFound) {
+		return nil, http.StatusNotFound, err
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if u := currentUser(r); u != nil {
+		if u.canAccess(hook) {
+			return hook, http.StatusOK, nil
+		}
+		return nil, http.StatusForbidden, errForbidden
+	}
+	if hook.checkToken(requestToken(r)) {
+		return hook, http.StatusOK, nil
+	}
+	return nil, http.StatusUnauthorized, errors.New("unauthorized")
+}
+
+// checkTeam 普通用户只能把 Hook 归属到自己所在的团队
+func checkTeam(u *User, team string) error {
+	if team == "" || u.Admin || slices.Contains(u.Teams, team) {
+		return nil
+	}
+	return fmt.Errorf("not a member of team %q", team)
+}
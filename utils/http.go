@@ -2,39 +2,40 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"time"
 )
 
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
 // HttpGetJSON GET请求并解析json
-func HttpGetJSON(url string, respObj interface{}) error {
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Get(url)
+func HttpGetJSON(ctx context.Context, url string, respObj interface{}, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return err
+		return RedactError(err)
 	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return err
+	for k, v := range headers {
+		req.Header.Set(k, v)
 	}
-	return json.Unmarshal(body, respObj)
+	return doJSON(req, respObj)
 }
 
 // HttpPostJSON POST请求json并解析json
-func HttpPostJSON(url string, data interface{}, respObj interface{}, headers map[string]string) error {
-	client := &http.Client{Timeout: 10 * time.Second}
-
+func HttpPostJSON(ctx context.Context, url string, data interface{}, respObj interface{}, headers map[string]string) error {
 	b, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewReader(b))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
 	if err != nil {
-		return err
+		return RedactError(err)
 	}
 
 	// 设置 Content-Type
@@ -44,17 +45,37 @@ func HttpPostJSON(url string, data interface{}, respObj interface{}, headers map
 	for k, v := range headers {
 		req.Header.Set(k, v)
 	}
+	return doJSON(req, respObj)
+}
 
-	resp, err := client.Do(req)
+// doJSON 发送请求并解析响应，在 debug 级别记录脱敏后的地址、状态码和耗时
+func doJSON(req *http.Request, respObj interface{}) (err error) {
+	start := time.Now()
+	status := 0
+	defer func() {
+		slog.DebugContext(req.Context(), "http call", "method", req.Method, "url", RedactURL(req.URL.String()),
+			"status", status, "duration", time.Since(start), "error", err)
+	}()
+
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		return err
+		return RedactError(err)
 	}
 	defer resp.Body.Close()
+	status = resp.StatusCode
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return err
 	}
-
 	return json.Unmarshal(body, respObj)
 }
+
+// RedactError 去掉 *url.Error 中地址携带的凭据，其余错误原样返回
+func RedactError(err error) error {
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		ue.URL = RedactURL(ue.URL)
+	}
+	return err
+}
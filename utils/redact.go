@@ -0,0 +1,15 @@
+package utils
+
+import "regexp"
+
+// secretParams 匹配查询参数形式的凭据，如企业微信的 corpsecret、access_token，
+// 钉钉的 appsecret，群机器人的 key
+var secretParams = regexp.MustCompile(`(?i)\b(access_token|corpsecret|appsecret|app_secret|secret|token|key|sign)=[^&\s"']+`)
+
+// Redacted 替换凭据后的占位符
+const Redacted = "REDACTED"
+
+// RedactURL 将地址或任意文本中查询参数形式的凭据替换为 Redacted
+func RedactURL(s string) string {
+	return secretParams.ReplaceAllString(s, "${1}="+Redacted)
+}
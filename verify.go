@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"webhook-proxy/utils"
+)
+
+// 入站签名校验方式
+const (
+	VerifyHMAC   = "hmac"   // 请求头携带请求体的 HMAC-SHA256，十六进制，可带 sha256= 前缀
+	VerifyGitHub = "github" // X-Hub-Signature-256: sha256=<hex>
+	VerifyGitLab = "gitlab" // X-Gitlab-Token 与密钥一致
+	VerifyStripe = "stripe" // Stripe-Signature: t=<unix>,v1=<hex>，签名内容为 "t.body"
+	VerifySlack  = "slack"  // X-Slack-Signature: v0=<hex>，签名内容为 "v0:timestamp:body"
+)
+
+var verifySchemes = []string{VerifyHMAC, VerifyGitHub, VerifyGitLab, VerifyStripe, VerifySlack}
+
+// defaultTolerance 带时间戳的签名允许的最大时间偏差
+const defaultTolerance = 5 * time.Minute
+
+// Verification 入站请求的签名校验配置，未通过校验的请求会被拒绝
+type Verification struct {
+	Scheme    string        `json:"scheme"`
+	Secret    string        `json:"secret"`
+	Header    string        `json:"header,omitempty"`    // hmac 方式的签名请求头，默认 X-Signature
+	Tolerance time.Duration `json:"tolerance,omitempty"` // stripe、slack 方式的时间偏差，默认 5 分钟
+}
+
+// Validate 检查校验方式与密钥
+func (v *Verification) Validate() error {
+	if !slices.Contains(verifySchemes, v.Scheme) {
+		return fmt.Errorf("unknown verification scheme %q", v.Scheme)
+	}
+	if v.Secret == "" {
+		return errors.New("verification secret is required")
+	}
+	if v.Tolerance < 0 {
+		return errors.New("tolerance must not be negative")
+	}
+	return nil
+}
+
+// verify 校验入站请求，失败时返回原因
+func (v *Verification) verify(in *inboundRequest, now time.Time) error {
+	switch v.Scheme {
+	case VerifyHMAC:
+		header := v.Header
+		if header == "" {
+			header = "X-Signature"
+		}
+		sig := in.Header.Get(header)
+		if sig == "" {
+			return fmt.Errorf("missing %s header", header)
+		}
+		return v.checkHMAC(strings.TrimPrefix(sig, "sha256="), in.Body)
+	case VerifyGitHub:
+		sig := in.Header.Get("X-Hub-Signature-256")
+		if sig == "" {
+			return errors.New("missing X-Hub-Signature-256 header")
+		}
+		hexSig, ok := strings.CutPrefix(sig, "sha256=")
+		if !ok {
+			return errors.New("malformed X-Hub-Signature-256 header")
+		}
+		return v.checkHMAC(hexSig, in.Body)
+	case VerifyGitLab:
+		token := in.Header.Get("X-Gitlab-Token")
+		if token == "" {
+			return errors.New("missing X-Gitlab-Token header")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(v.Secret)) != 1 {
+			return errors.New("X-Gitlab-Token mismatch")
+		}
+		return nil
+	case VerifyStripe:
+		return v.verifyStripe(in, now)
+	case VerifySlack:
+		return v.verifySlack(in, now)
+	}
+	return fmt.Errorf("unknown verification scheme %q", v.Scheme)
+}
+
+func (v *Verification) verifyStripe(in *inboundRequest, now time.Time) error {
+	header := in.Header.Get("Stripe-Signature")
+	if header == "" {
+		return errors.New("missing Stripe-Signature header")
+	}
+	var ts string
+	var sigs []string
+	for _, part := range strings.Split(header, ",") {
+		k, val, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts = val
+		case "v1":
+			sigs = append(sigs, val)
+		}
+	}
+	if ts == "" || len(sigs) == 0 {
+		return errors.New("malformed Stripe-Signature header")
+	}
+	if err := v.checkTimestamp(ts, now); err != nil {
+		return err
+	}
+	// 密钥轮换期间可能同时携带多个 v1 签名，任意一个匹配即可
+	payload := append([]byte(ts+"."), in.Body...)
+	for _, sig := range sigs {
+		if v.checkHMAC(sig, payload) == nil {
+			return nil
+		}
+	}
+	return errors.New("signature mismatch")
+}
+
+func (v *Verification) verifySlack(in *inboundRequest, now time.Time) error {
+	ts := in.Header.Get("X-Slack-Request-Timestamp")
+	sig := in.Header.Get("X-Slack-Signature")
+	if ts == "" || sig == "" {
+		return errors.New("missing X-Slack-Request-Timestamp or X-Slack-Signature header")
+	}
+	hexSig, ok := strings.CutPrefix(sig, "v0=")
+	if !ok {
+		return errors.New("malformed X-Slack-Signature header")
+	}
+	if err := v.checkTimestamp(ts, now); err != nil {
+		return err
+	}
+	return v.checkHMAC(hexSig, append([]byte("v0:"+ts+":"), in.Body...))
+}
+
+// checkTimestamp 拒绝超出容忍窗口的 Unix 秒时间戳，防止重放攻击
+func (v *Verification) checkTimestamp(ts string, now time.Time) error {
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q", ts)
+	}
+	tolerance := v.Tolerance
+	if tolerance == 0 {
+		tolerance = defaultTolerance
+	}
+	if skew := math.Abs(now.Sub(time.Unix(sec, 0)).Seconds()); skew > tolerance.Seconds() {
+		return fmt.Errorf("timestamp outside tolerance (%s)", tolerance)
+	}
+	return nil
+}
+
+func (v *Verification) checkHMAC(hexSig string, payload []byte) error {
+	got, err := hex.DecodeString(hexSig)
+	if err != nil {
+		return errors.New("malformed signature")
+	}
+	mac := hmac.New(sha256.New, []byte(v.Secret))
+	mac.Write(payload)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// parseVerifyForm 从表单读取签名校验配置，未选择校验方式时返回 nil
+func parseVerifyForm(r *http.Request) (*Verification, error) {
+	scheme := r.FormValue("verify_scheme")
+	if scheme == "" {
+		return nil, nil
+	}
+	v := &Verification{
+		Scheme: scheme,
+		Secret: strings.TrimSpace(r.FormValue("verify_secret")),
+		Header: http.CanonicalHeaderKey(strings.TrimSpace(r.FormValue("verify_header"))),
+	}
+	if s := r.FormValue("verify_tolerance"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tolerance %q", s)
+		}
+		v.Tolerance = d
+	}
+	if err := v.Validate(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// secretHeaderWords 请求头名称按 - 分段后含有这些词时，视为携带凭据或签名，
+// 如 Authorization、X-Gitlab-Token、X-Hub-Signature-256、Stripe-Signature、X-Api-Key
+var secretHeaderWords = []string{"authorization", "cookie", "token", "secret", "signature", "sig", "hmac", "key", "apikey", "password"}
+
+func secretHeader(name string) bool {
+	for _, word := range strings.Split(strings.ToLower(name), "-") {
+		if slices.Contains(secretHeaderWords, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactHeaders 返回请求头的副本，凭据与签名的值替换为 utils.Redacted。
+// 日志会通过 /logs、API 与实时推送展示，保存前需要脱敏
+func redactHeaders(h http.Header) http.Header {
+	out := h.Clone()
+	for name, values := range out {
+		if secretHeader(name) {
+			for i := range values {
+				values[i] = utils.Redacted
+			}
+		}
+	}
+	return out
+}
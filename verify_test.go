@@ -0,0 +1,235 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slackBody、slackSignature 来自 Slack 文档 Verifying requests from Slack 的示例
+const (
+	slackSecret    = "8f742231b10e8888abcd99yyyzzz85a5"
+	slackTimestamp = "1531420618"
+	slackBody      = "token=xyzz0WbapA4vBCDEFasx0q6G&team_id=T1DC2JH3J&team_domain=testteamnow&channel_id=G8PSS9T3V&channel_name=foobar&user_id=U2CERLKJA&user_name=roadrunner&command=%2Fwebhook-collect&text=&response_url=https%3A%2F%2Fhooks.slack.com%2Fcommands%2FT1DC2JH3J%2F397700885554%2F96rGlfmibIGlgcZRskXaIFfN&trigger_id=398738663015.47445629121.803a0bc887a14d10d2c447fce8b6703c"
+	slackSignature = "v0=a2114d57b48eac39b9ad189dd8316235a7b4a8d21a10bd27519666489c69b503"
+)
+
+// githubSignature 来自 GitHub 文档 Validating webhook deliveries 的示例：
+// 密钥 "It's a Secret to Everybody"，请求体 "Hello, World!"
+const githubSignature = "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"
+
+// stripeSignature 按 Stripe 文档的格式对 "1700000000.{"id":"evt_1"}" 计算，密钥为 whsec_test
+const stripeSignature = "c89214b5b5da833daed6f0b8c5bb6bd58cea9022bd80ccc78230f3942d632925"
+
+func TestVerify(t *testing.T) {
+	slackTime := time.Unix(1531420618, 0)
+	stripeTime := time.Unix(1700000000, 0)
+	tests := []struct {
+		name    string
+		v       Verification
+		header  map[string]string
+		body    string
+		now     time.Time
+		wantErr string
+	}{
+		{
+			name:   "github",
+			v:      Verification{Scheme: VerifyGitHub, Secret: "It's a Secret to Everybody"},
+			header: map[string]string{"X-Hub-Signature-256": githubSignature},
+			body:   "Hello, World!",
+		},
+		{
+			name:    "github wrong secret",
+			v:       Verification{Scheme: VerifyGitHub, Secret: "another secret"},
+			header:  map[string]string{"X-Hub-Signature-256": githubSignature},
+			body:    "Hello, World!",
+			wantErr: "signature mismatch",
+		},
+		{
+			name:    "github modified body",
+			v:       Verification{Scheme: VerifyGitHub, Secret: "It's a Secret to Everybody"},
+			header:  map[string]string{"X-Hub-Signature-256": githubSignature},
+			body:    "Hello, World?",
+			wantErr: "signature mismatch",
+		},
+		{
+			name:    "github missing header",
+			v:       Verification{Scheme: VerifyGitHub, Secret: "It's a Secret to Everybody"},
+			body:    "Hello, World!",
+			wantErr: "missing X-Hub-Signature-256",
+		},
+		{
+			name:    "github without prefix",
+			v:       Verification{Scheme: VerifyGitHub, Secret: "It's a Secret to Everybody"},
+			header:  map[string]string{"X-Hub-Signature-256": strings.TrimPrefix(githubSignature, "sha256=")},
+			body:    "Hello, World!",
+			wantErr: "malformed",
+		},
+		{
+			name:   "hmac default header",
+			v:      Verification{Scheme: VerifyHMAC, Secret: "It's a Secret to Everybody"},
+			header: map[string]string{"X-Signature": strings.TrimPrefix(githubSignature, "sha256=")},
+			body:   "Hello, World!",
+		},
+		{
+			name:   "hmac custom header with prefix",
+			v:      Verification{Scheme: VerifyHMAC, Secret: "It's a Secret to Everybody", Header: "X-Hook-Signature"},
+			header: map[string]string{"X-Hook-Signature": githubSignature},
+			body:   "Hello, World!",
+		},
+		{
+			name:    "hmac not hex",
+			v:       Verification{Scheme: VerifyHMAC, Secret: "It's a Secret to Everybody"},
+			header:  map[string]string{"X-Signature": "not-hex"},
+			body:    "Hello, World!",
+			wantErr: "malformed signature",
+		},
+		{
+			name:    "hmac missing header",
+			v:       Verification{Scheme: VerifyHMAC, Secret: "It's a Secret to Everybody", Header: "X-Hook-Signature"},
+			header:  map[string]string{"X-Signature": githubSignature},
+			body:    "Hello, World!",
+			wantErr: "missing X-Hook-Signature",
+		},
+		{
+			name:   "gitlab",
+			v:      Verification{Scheme: VerifyGitLab, Secret: "s3cret"},
+			header: map[string]string{"X-Gitlab-Token": "s3cret"},
+		},
+		{
+			name:    "gitlab wrong token",
+			v:       Verification{Scheme: VerifyGitLab, Secret: "s3cret"},
+			header:  map[string]string{"X-Gitlab-Token": "s3cre"},
+			wantErr: "mismatch",
+		},
+		{
+			name:    "gitlab missing header",
+			v:       Verification{Scheme: VerifyGitLab, Secret: "s3cret"},
+			wantErr: "missing X-Gitlab-Token",
+		},
+		{
+			name:   "slack",
+			v:      Verification{Scheme: VerifySlack, Secret: slackSecret},
+			header: map[string]string{"X-Slack-Request-Timestamp": slackTimestamp, "X-Slack-Signature": slackSignature},
+			body:   slackBody,
+			now:    slackTime.Add(time.Minute),
+		},
+		{
+			name:    "slack bad signature",
+			v:       Verification{Scheme: VerifySlack, Secret: slackSecret},
+			header:  map[string]string{"X-Slack-Request-Timestamp": slackTimestamp, "X-Slack-Signature": slackSignature},
+			body:    slackBody + "&extra=1",
+			now:     slackTime,
+			wantErr: "signature mismatch",
+		},
+		{
+			name:    "slack stale timestamp",
+			v:       Verification{Scheme: VerifySlack, Secret: slackSecret},
+			header:  map[string]string{"X-Slack-Request-Timestamp": slackTimestamp, "X-Slack-Signature": slackSignature},
+			body:    slackBody,
+			now:     slackTime.Add(6 * time.Minute),
+			wantErr: "outside tolerance",
+		},
+		{
+			name:   "slack custom tolerance",
+			v:      Verification{Scheme: VerifySlack, Secret: slackSecret, Tolerance: 10 * time.Minute},
+			header: map[string]string{"X-Slack-Request-Timestamp": slackTimestamp, "X-Slack-Signature": slackSignature},
+			body:   slackBody,
+			now:    slackTime.Add(-6 * time.Minute),
+		},
+		{
+			name:    "slack missing timestamp",
+			v:       Verification{Scheme: VerifySlack, Secret: slackSecret},
+			header:  map[string]string{"X-Slack-Signature": slackSignature},
+			body:    slackBody,
+			now:     slackTime,
+			wantErr: "missing",
+		},
+		{
+			name:   "stripe",
+			v:      Verification{Scheme: VerifyStripe, Secret: "whsec_test"},
+			header: map[string]string{"Stripe-Signature": "t=1700000000,v1=" + stripeSignature},
+			body:   `{"id":"evt_1"}`,
+			now:    stripeTime,
+		},
+		{
+			name:   "stripe rotated secret",
+			v:      Verification{Scheme: VerifyStripe, Secret: "whsec_test"},
+			header: map[string]string{"Stripe-Signature": "t=1700000000,v1=00ff,v1=" + stripeSignature + ",v0=abcd"},
+			body:   `{"id":"evt_1"}`,
+			now:    stripeTime,
+		},
+		{
+			name:    "stripe bad signature",
+			v:       Verification{Scheme: VerifyStripe, Secret: "whsec_other"},
+			header:  map[string]string{"Stripe-Signature": "t=1700000000,v1=" + stripeSignature},
+			body:    `{"id":"evt_1"}`,
+			now:     stripeTime,
+			wantErr: "signature mismatch",
+		},
+		{
+			name:    "stripe stale timestamp",
+			v:       Verification{Scheme: VerifyStripe, Secret: "whsec_test"},
+			header:  map[string]string{"Stripe-Signature": "t=1700000000,v1=" + stripeSignature},
+			body:    `{"id":"evt_1"}`,
+			now:     stripeTime.Add(time.Hour),
+			wantErr: "outside tolerance",
+		},
+		{
+			name:    "stripe missing v1",
+			v:       Verification{Scheme: VerifyStripe, Secret: "whsec_test"},
+			header:  map[string]string{"Stripe-Signature": "t=1700000000"},
+			body:    `{"id":"evt_1"}`,
+			now:     stripeTime,
+			wantErr: "malformed",
+		},
+		{
+			name:    "stripe missing header",
+			v:       Verification{Scheme: VerifyStripe, Secret: "whsec_test"},
+			body:    `{"id":"evt_1"}`,
+			now:     stripeTime,
+			wantErr: "missing Stripe-Signature",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := &inboundRequest{Header: http.Header{}, Body: []byte(tt.body)}
+			for k, v := range tt.header {
+				in.Header.Set(k, v)
+			}
+			err := tt.v.verify(in, tt.now)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("verify() = %v, want nil", err)
+			case tt.wantErr != "" && err == nil:
+				t.Fatalf("verify() = nil, want error containing %q", tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Fatalf("verify() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRedactHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("X-Hub-Signature-256", githubSignature)
+	h.Set("Authorization", "Bearer token")
+	h.Set("X-Api-Key", "key")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Keyboard-Layout", "qwerty")
+	got := redactHeaders(h)
+	for _, name := range []string{"X-Hub-Signature-256", "Authorization", "X-Api-Key"} {
+		if v := got.Get(name); v != "REDACTED" {
+			t.Errorf("%s = %q, want REDACTED", name, v)
+		}
+	}
+	for _, name := range []string{"Content-Type", "X-Keyboard-Layout"} {
+		if got.Get(name) != h.Get(name) {
+			t.Errorf("%s = %q, want unchanged", name, got.Get(name))
+		}
+	}
+	if h.Get("Authorization") != "Bearer token" {
+		t.Error("redactHeaders modified the original header")
+	}
+}